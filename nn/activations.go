@@ -1,34 +1,99 @@
 package neuralnetwork
 
 import (
+	"fmt"
 	"math"
 )
 
-//Sigmoid activation function
-func Sigmoid(x float64) float64 {
+//Activation is an activation function paired with its derivative, which layers use in their backward pass. Custom
+//activations, for instance parameterized ones, are built the same way:
+//
+//	func LeakyRelu(alpha float64) nn.Activation {
+//		derivative := func(x float64) float64 {
+//			if x < 0 {
+//				return alpha
+//			}
+//			return 1
+//		}
+//		return nn.Activation{Name: "leaky_relu", Func: func(x float64) float64 { return math.Max(x, alpha*x) }, Derivative: derivative}
+//	}
+//
+//The zero Activation is the identity, like Linear.
+type Activation struct {
+	Name       string
+	Func       func(float64) float64
+	Derivative func(float64) float64
+}
+
+//Activations of the package.
+var (
+	Sigmoid = Activation{Name: "sigmoid", Func: sigmoid, Derivative: sigmoidPrime}
+	Tanh    = Activation{Name: "tanh", Func: math.Tanh, Derivative: tanhPrime}
+	Relu    = Activation{Name: "relu", Func: relu, Derivative: reluPrime}
+	Linear  = Activation{Name: "linear", Func: linear, Derivative: linearPrime}
+	Elu     = Activation{Name: "elu", Func: elu, Derivative: eluPrime}
+	Swish   = Activation{Name: "swish", Func: swish, Derivative: swishPrime}
+)
+
+//resolve returns the activation a layer applies: a itself, or Linear for the zero Activation. An activation missing
+//its function or its derivative is an error; layers report it when they are built.
+func (a Activation) resolve() (Activation, error) {
+	switch {
+	case a.Func == nil && a.Derivative == nil:
+		return Linear, nil
+	case a.Func == nil:
+		return Activation{}, fmt.Errorf("activation %q has no function", a.Name)
+	case a.Derivative == nil:
+		return Activation{}, fmt.Errorf("activation %q has no derivative", a.Name)
+	}
+	return a, nil
+}
+
+//sigmoid is the logistic function.
+func sigmoid(x float64) float64 {
 	return 1 / (1 + math.Exp(-x))
 }
 
-//SigmoidPrime is the derivative of the Sigmoid
-func SigmoidPrime(x float64) float64 {
-	return Sigmoid(x) * (1 - Sigmoid(x))
+//sigmoidPrime is the derivative of sigmoid.
+func sigmoidPrime(x float64) float64 {
+	s := sigmoid(x)
+	return s * (1 - s)
+}
+
+//tanhPrime is the derivative of tanh.
+func tanhPrime(x float64) float64 {
+	t := math.Tanh(x)
+	return 1 - t*t
 }
 
-//Tanh returns the tanh activation function.
-func Tanh(x float64) float64 {
-	return math.Tanh(x)
+//relu is the rectified linear unit.
+func relu(x float64) float64 {
+	if x < 0 {
+		return 0
+	}
+	return x
 }
 
-//Relu implements the rectified linear unit.
-func Relu(x float64) float64 {
+//reluPrime is the derivative of relu.
+func reluPrime(x float64) float64 {
 	if x < 0 {
 		return 0
 	}
+	return 1
+}
+
+//linear is the identity.
+func linear(x float64) float64 {
 	return x
 }
 
+//linearPrime is the derivative of linear.
+func linearPrime(x float64) float64 {
+	return 1
+}
+
 func findMax(fls []float64) float64 {
-	max := -10000.0
+	max := math.Inf(-1)
 	for _, k := range fls {
 		if k > max {
 			max = k
@@ -37,16 +102,31 @@ func findMax(fls []float64) float64 {
 	return max
 }
 
-//Elu is an activation function. 0.7 is a parameter that should be above 0
-func Elu(x float64) float64 {
+//elu is the exponential linear unit with an alpha of 0.7.
+func elu(x float64) float64 {
 	if x > 0 {
 		return x
 	}
 	return 0.7 * (math.Exp(x) - 1)
 }
 
-//Swish activation function. Beta is a parameter that should be above 0
-func Swish(x float64) float64 {
+//eluPrime is the derivative of elu.
+func eluPrime(x float64) float64 {
+	if x > 0 {
+		return 1
+	}
+	return 0.7 * math.Exp(x)
+}
+
+//swish is x * sigmoid(beta * x) with a beta of 0.8.
+func swish(x float64) float64 {
+	beta := 0.8
+	return x * sigmoid(beta*x)
+}
+
+//swishPrime is the derivative of swish.
+func swishPrime(x float64) float64 {
 	beta := 0.8
-	return x * Sigmoid(beta*x)
+	s := sigmoid(beta * x)
+	return s + beta*x*s*(1-s)
 }
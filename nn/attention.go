@@ -0,0 +1,366 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//projection is a kernel and a bias applied to the rows of a raw [rows, in] buffer.
+type projection struct {
+	kernel, bias *Parameter
+	in, out      int
+}
+
+//newProjection adds the kernel and the bias of a projection to the weights of layer.
+func newProjection(layer *BaseLayer, name string, in, out int, kernelInit, biasInit func(float64) float64) *projection {
+	return &projection{
+		kernel: layer.AddWeight(name+"/kernel", KernelWeight, []int{in, out}, kernelInit, true),
+		bias:   layer.AddWeight(name+"/bias", BiasWeight, []int{out}, biasInit, true),
+		in:     in,
+		out:    out,
+	}
+}
+
+//forward returns x kernel + bias.
+func (p *projection) forward(x []float64, rows int) []float64 {
+	y := make([]float64, rows*p.out)
+	b := p.bias.Value.Data()
+	for i := 0; i < rows; i++ {
+		copy(y[i*p.out:(i+1)*p.out], b)
+	}
+	gemm(rows, p.in, p.out, x, p.in, p.kernel.Value.Data(), p.out, y, p.out)
+	return y
+}
+
+//backward accumulates the kernel and bias gradients for the inputs x and the output gradient dy, and adds the
+//gradient with respect to x to dx.
+func (p *projection) backward(x, dy []float64, rows int, dx []float64) error {
+	kernelGrad, biasGrad := make([]float64, p.in*p.out), make([]float64, p.out)
+	gemmTA(rows, p.in, p.out, x, p.in, dy, p.out, kernelGrad, p.out)
+	for i := 0; i < rows; i++ {
+		for j, v := range dy[i*p.out : (i+1)*p.out] {
+			biasGrad[j] += v
+		}
+	}
+	gemmTB(rows, p.in, p.out, dy, p.out, p.kernel.Value.Data(), p.out, dx, p.in)
+	if err := accumulateData(p.kernel, kernelGrad); err != nil {
+		return err
+	}
+	return accumulateData(p.bias, biasGrad)
+}
+
+//MultiHeadAttentionLayer is scaled dot-product self-attention with several heads over inputs of shape
+//[batch, time, features]. Every head projects the inputs to queries, keys and values of KeyDim values; the heads'
+//results are concatenated and projected back to the number of input features.
+type MultiHeadAttentionLayer struct {
+	BaseLayer
+	heads, keyDim      int
+	query, key, value  *projection
+	output             *projection
+	mask               *tensor.Tensor
+	inputs             []float64
+	q, k, v, attended  []float64
+	scores             []float64
+	batch, steps, dims int
+	//Causal stops every step from attending to the following ones.
+	Causal     bool
+	KernelInit func(float64) float64
+	BiasInit   func(float64) float64
+}
+
+//MultiHeadAttention returns a self-attention layer with the given number of heads, each with queries and keys of
+//keyDim values.
+func MultiHeadAttention(heads, keyDim int) *MultiHeadAttentionLayer {
+	return newMultiHeadAttention(uniqueName("multi_head_attention"), heads, keyDim)
+}
+
+//newMultiHeadAttention returns a multi-head attention layer named name.
+func newMultiHeadAttention(name string, heads, keyDim int) *MultiHeadAttentionLayer {
+	return &MultiHeadAttentionLayer{BaseLayer: newBaseLayer(name),
+		heads:      heads,
+		keyDim:     keyDim,
+		KernelInit: HeUniform,
+		BiasInit:   ZeroInitializer,
+	}
+}
+
+//Build creates the query, key, value and output projections.
+func (a *MultiHeadAttentionLayer) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != 2 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [time, features], got %v", a.name, inputShape)
+	}
+	features, width := inputShape[1], a.heads*a.keyDim
+	if a.query == nil {
+		a.query = newProjection(&a.BaseLayer, "query", features, width, a.KernelInit, a.BiasInit)
+		a.key = newProjection(&a.BaseLayer, "key", features, width, a.KernelInit, a.BiasInit)
+		a.value = newProjection(&a.BaseLayer, "value", features, width, a.KernelInit, a.BiasInit)
+		a.output = newProjection(&a.BaseLayer, "output", width, features, a.KernelInit, a.BiasInit)
+	} else if a.query.in != features {
+		return nil, fmt.Errorf("%s: expected %d input features, got shape %v", a.name, a.query.in, inputShape)
+	}
+	return append([]int(nil), inputShape...), nil
+}
+
+//Forward attends every step of every sample to the steps of the same sample.
+func (a *MultiHeadAttentionLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", a.name, err)
+	}
+	if _, err := a.Build(shape); err != nil {
+		return nil, err
+	}
+	batch, steps, dims := inputs.Shape()[0], shape[0], shape[1]
+	var keep []float64
+	if a.mask != nil {
+		if !equalShapes(a.mask.Shape(), []int{batch, steps}) {
+			return nil, fmt.Errorf("%s: mask of shape %v does not match inputs of shape %v", a.name, a.mask.Shape(), inputs.Shape())
+		}
+		keep = a.mask.Data()
+	}
+	x := inputs.Data()
+	rows, width := batch*steps, a.heads*a.keyDim
+	q, k, v := a.query.forward(x, rows), a.key.forward(x, rows), a.value.forward(x, rows)
+	scale := 1 / math.Sqrt(float64(a.keyDim))
+	scores := make([]float64, batch*a.heads*steps*steps)
+	attended := make([]float64, rows*width)
+	for b := 0; b < batch; b++ {
+		for h := 0; h < a.heads; h++ {
+			off := b*steps*width + h*a.keyDim
+			s := scores[(b*a.heads+h)*steps*steps : (b*a.heads+h+1)*steps*steps]
+			gemmTB(steps, steps, a.keyDim, q[off:], width, k[off:], width, s, steps)
+			for i := 0; i < steps; i++ {
+				row := s[i*steps : (i+1)*steps]
+				max := math.Inf(-1)
+				for j := range row {
+					if (a.Causal && j > i) || (keep != nil && keep[b*steps+j] == 0) {
+						row[j] = math.Inf(-1)
+						continue
+					}
+					row[j] *= scale
+					max = math.Max(max, row[j])
+				}
+				if math.IsInf(max, -1) {
+					//every key is masked, the step attends to nothing
+					for j := range row {
+						row[j] = 0
+					}
+					continue
+				}
+				var sum float64
+				for j := range row {
+					row[j] = math.Exp(row[j] - max)
+					sum += row[j]
+				}
+				for j := range row {
+					row[j] /= sum
+				}
+			}
+			gemm(steps, steps, a.keyDim, s, steps, v[off:], width, attended[off:], width)
+		}
+	}
+	a.inputs, a.q, a.k, a.v, a.attended, a.scores = x, q, k, v, attended, scores
+	a.batch, a.steps, a.dims = batch, steps, dims
+	return tensor.FromSlice(a.output.forward(attended, rows), inputs.Shape())
+}
+
+//Backward accumulates the gradients of the projections and returns the gradient with respect to the inputs.
+func (a *MultiHeadAttentionLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if a.scores == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", a.name)
+	}
+	batch, steps, dims := a.batch, a.steps, a.dims
+	rows, width := batch*steps, a.heads*a.keyDim
+	g := gradOutput.Data()
+	if len(g) != rows*dims {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", a.name, gradOutput.Shape())
+	}
+	dAttended := make([]float64, rows*width)
+	if err := a.output.backward(a.attended, g, rows, dAttended); err != nil {
+		return nil, err
+	}
+	scale := 1 / math.Sqrt(float64(a.keyDim))
+	dq, dk, dv := make([]float64, rows*width), make([]float64, rows*width), make([]float64, rows*width)
+	dScores := make([]float64, steps*steps)
+	for b := 0; b < batch; b++ {
+		for h := 0; h < a.heads; h++ {
+			off := b*steps*width + h*a.keyDim
+			s := a.scores[(b*a.heads+h)*steps*steps : (b*a.heads+h+1)*steps*steps]
+			for i := range dScores {
+				dScores[i] = 0
+			}
+			gemmTB(steps, steps, a.keyDim, dAttended[off:], width, a.v[off:], width, dScores, steps)
+			gemmTA(steps, steps, a.keyDim, s, steps, dAttended[off:], width, dv[off:], width)
+			//softmax backward, then the scaling
+			for i := 0; i < steps; i++ {
+				p, d := s[i*steps:(i+1)*steps], dScores[i*steps:(i+1)*steps]
+				var dot float64
+				for j := range p {
+					dot += p[j] * d[j]
+				}
+				for j := range p {
+					d[j] = p[j] * (d[j] - dot) * scale
+				}
+			}
+			gemm(steps, steps, a.keyDim, dScores, steps, a.k[off:], width, dq[off:], width)
+			gemmTA(steps, steps, a.keyDim, dScores, steps, a.q[off:], width, dk[off:], width)
+		}
+	}
+	dx := make([]float64, rows*dims)
+	for _, pr := range []struct {
+		p  *projection
+		dy []float64
+	}{{a.query, dq}, {a.key, dk}, {a.value, dv}} {
+		if err := pr.p.backward(a.inputs, pr.dy, rows, dx); err != nil {
+			return nil, err
+		}
+	}
+	return tensor.FromSlice(dx, []int{batch, steps, dims})
+}
+
+//SetMask sets the [batch, time] padding mask of the following Forward calls: no step attends to a padding step.
+func (a *MultiHeadAttentionLayer) SetMask(mask *tensor.Tensor) {
+	a.mask = mask
+}
+
+//Mask passes the padding mask on to the next layer.
+func (a *MultiHeadAttentionLayer) Mask() *tensor.Tensor {
+	return a.mask
+}
+
+//AttentionScores returns the attention weights of the last Forward call, of shape [batch, heads, time, time].
+func (a *MultiHeadAttentionLayer) AttentionScores() *tensor.Tensor {
+	if a.scores == nil {
+		return nil
+	}
+	t, _ := tensor.FromSlice(append([]float64(nil), a.scores...), []int{a.batch, a.heads, a.steps, a.steps})
+	return t
+}
+
+//TransformerEncoderLayer is a transformer encoder block over inputs of shape [batch, time, features]:
+//h = LayerNorm(x + MultiHeadAttention(x)), followed by LayerNorm(h + Dense(Dense(h, relu))).
+type TransformerEncoderLayer struct {
+	BaseLayer
+	attention   *MultiHeadAttentionLayer
+	norm1       *normalization
+	hidden, out *DenseLayer
+	norm2       *normalization
+	ffDim       int
+}
+
+//TransformerEncoder returns an encoder block whose attention has the given heads and key dimension and whose
+//feed-forward sublayer has ffDim hidden units.
+func TransformerEncoder(heads, keyDim, ffDim int) *TransformerEncoderLayer {
+	name := uniqueName("transformer_encoder")
+	return &TransformerEncoderLayer{BaseLayer: newBaseLayer(name),
+		attention: newMultiHeadAttention(name+"/attention", heads, keyDim),
+		norm1:     newLayerNorm(name + "/norm1"),
+		hidden:    newDense(name+"/hidden", ffDim, Relu),
+		norm2:     newLayerNorm(name + "/norm2"),
+		ffDim:     ffDim,
+	}
+}
+
+//SetName renames the block and its sublayers. Call it before the block is built.
+func (e *TransformerEncoderLayer) SetName(name string) {
+	e.BaseLayer.SetName(name)
+	e.attention.SetName(name + "/attention")
+	e.norm1.SetName(name + "/norm1")
+	e.hidden.SetName(name + "/hidden")
+	e.norm2.SetName(name + "/norm2")
+}
+
+//Attention returns the block's attention layer, for instance to make it causal.
+func (e *TransformerEncoderLayer) Attention() *MultiHeadAttentionLayer {
+	return e.attention
+}
+
+//Build builds the sublayers.
+func (e *TransformerEncoderLayer) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != 2 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [time, features], got %v", e.name, inputShape)
+	}
+	if e.out == nil {
+		e.out = newDense(e.name+"/output", inputShape[1], Linear)
+		e.Track(e.attention, e.norm1, e.hidden, e.out, e.norm2)
+	}
+	shape := inputShape
+	for _, l := range e.Layers() {
+		var err error
+		if shape, err = l.Build(shape); err != nil {
+			return nil, err
+		}
+	}
+	return shape, nil
+}
+
+//Forward runs the attention and feed-forward sublayers with their residual connections.
+func (e *TransformerEncoderLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", e.name, err)
+	}
+	if _, err := e.Build(shape); err != nil {
+		return nil, err
+	}
+	attended, err := e.attention.Forward(inputs, training)
+	if err != nil {
+		return nil, err
+	}
+	if attended, err = attended.Add(inputs); err != nil {
+		return nil, err
+	}
+	h, err := e.norm1.Forward(attended, training)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := e.hidden.Forward(h, training)
+	if err != nil {
+		return nil, err
+	}
+	if ff, err = e.out.Forward(ff, training); err != nil {
+		return nil, err
+	}
+	if ff, err = ff.Add(h); err != nil {
+		return nil, err
+	}
+	return e.norm2.Forward(ff, training)
+}
+
+//Backward propagates the gradient through both sublayers and their residual connections.
+func (e *TransformerEncoderLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	g, err := e.norm2.Backward(gradOutput)
+	if err != nil {
+		return nil, err
+	}
+	dff, err := e.out.Backward(g)
+	if err != nil {
+		return nil, err
+	}
+	if dff, err = e.hidden.Backward(dff); err != nil {
+		return nil, err
+	}
+	if g, err = g.Add(dff); err != nil {
+		return nil, err
+	}
+	if g, err = e.norm1.Backward(g); err != nil {
+		return nil, err
+	}
+	dx, err := e.attention.Backward(g)
+	if err != nil {
+		return nil, err
+	}
+	return dx.Add(g)
+}
+
+//SetMask hands the padding mask to the attention sublayer.
+func (e *TransformerEncoderLayer) SetMask(mask *tensor.Tensor) {
+	e.attention.SetMask(mask)
+}
+
+//Mask passes the padding mask on to the next layer.
+func (e *TransformerEncoderLayer) Mask() *tensor.Tensor {
+	return e.attention.Mask()
+}
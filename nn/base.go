@@ -0,0 +1,152 @@
+package neuralnetwork
+
+//BaseLayer does the bookkeeping every layer needs: its name, its weights and the layers it is made of. Every layer of
+//the package embeds it, and so can layers defined outside the package: embedding a BaseLayer provides Name, SetName,
+//Parameters and TrainableParameters, so a custom layer only implements Build, Forward and Backward to take part in
+//Summary, the optimizers and checkpoints like the built-in layers. Build creates the weights with AddWeight, once,
+//and Forward builds the layer on its first call:
+//
+//	type ScaleLayer struct {
+//		nn.BaseLayer
+//		scale  *nn.Parameter
+//		inputs *tensor.Tensor
+//	}
+//
+//	func Scale() *ScaleLayer {
+//		return &ScaleLayer{BaseLayer: nn.NewBaseLayer("scale")}
+//	}
+//
+//	func (s *ScaleLayer) Build(inputShape []int) ([]int, error) {
+//		if s.scale == nil {
+//			s.scale = s.AddWeight("scale", nn.OtherWeight, inputShape, nn.OnesInitializer, true)
+//		}
+//		return inputShape, nil
+//	}
+//
+//	func (s *ScaleLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+//		if _, err := s.Build(inputs.Shape()[1:]); err != nil {
+//			return nil, err
+//		}
+//		s.inputs = inputs
+//		return inputs.Multiply(s.scale.Value)
+//	}
+//
+//Backward adds the gradient of every weight with Accumulate and returns the gradient with respect to the inputs.
+//Forward assigns new tensors and slices to what it keeps, like s.inputs, instead of writing into those of the
+//previous call: the model copies the fields of a layer it applies several times, unless the layer implements
+//StateSaver.
+type BaseLayer struct {
+	name      string
+	trainable bool
+	weights   []*Parameter
+	roles     []WeightRole
+	layers    []Layer
+	//KernelRegularizer, RecurrentRegularizer, BiasRegularizer and EmbeddingsRegularizer penalize the weights of the
+	//matching role, those of the tracked layers included unless they set their own, and ActivityRegularizer the
+	//outputs of the layer, averaged over the samples of the batch.
+	KernelRegularizer     Regularizer
+	RecurrentRegularizer  Regularizer
+	BiasRegularizer       Regularizer
+	EmbeddingsRegularizer Regularizer
+	ActivityRegularizer   Regularizer
+}
+
+//WeightRole tells AddWeight what a weight is for, which picks the regularizer penalizing it.
+type WeightRole int
+
+const (
+	//OtherWeight is not regularized, like the scale and shift of the normalization layers.
+	OtherWeight WeightRole = iota
+	//KernelWeight multiplies the inputs of the layer.
+	KernelWeight
+	//RecurrentWeight multiplies the states of a recurrent layer.
+	RecurrentWeight
+	//BiasWeight is added to the outputs of the layer.
+	BiasWeight
+	//EmbeddingsWeight holds a vector per index of an embedding table.
+	EmbeddingsWeight
+)
+
+//NewBaseLayer returns a base for a layer named after prefix, with a number appended to keep the name unique.
+func NewBaseLayer(prefix string) BaseLayer {
+	return newBaseLayer(uniqueName(prefix))
+}
+
+//newBaseLayer returns a base for a layer named name as is, for the sublayers named after the layer holding them.
+func newBaseLayer(name string) BaseLayer {
+	return BaseLayer{name: name, trainable: true}
+}
+
+//Name of the layer
+func (b *BaseLayer) Name() string {
+	return b.name
+}
+
+//SetName renames the layer. Call it before the layer is built: the names of the weights start with it.
+func (b *BaseLayer) SetName(name string) {
+	b.name = name
+}
+
+//AddWeight creates a weight of the given shape named "<layer name>/<name>", with every value set by init, and
+//registers it with the layer; role picks the regularizer penalizing it. Optimizers update the trainable weights from
+//their gradients; the layer updates the other ones itself, like the moving statistics of batch normalization. All of
+//them are saved in checkpoints.
+func (b *BaseLayer) AddWeight(name string, role WeightRole, shape []int, init func(float64) float64, trainable bool) *Parameter {
+	p := NewParameter(b.name+"/"+name, initTensor(shape, init))
+	p.Trainable, p.frozen = trainable, !b.trainable
+	b.weights, b.roles = append(b.weights, p), append(b.roles, role)
+	return p
+}
+
+//Track registers layers the layer is made of, whose parameters become parameters of the layer. They are frozen if
+//the layer is.
+func (b *BaseLayer) Track(layers ...Layer) {
+	b.layers = append(b.layers, layers...)
+	if !b.trainable {
+		setTrainable(layers, false)
+	}
+}
+
+//Layers returns the tracked layers.
+func (b *BaseLayer) Layers() []Layer {
+	return b.layers
+}
+
+//Parameters returns the weights of the layer followed by the parameters of the tracked layers, in the order they
+//were added.
+func (b *BaseLayer) Parameters() []*Parameter {
+	params := append([]*Parameter(nil), b.weights...)
+	for _, l := range b.layers {
+		params = append(params, l.Parameters()...)
+	}
+	return params
+}
+
+//TrainableParameters returns the count of trainable parameters.
+func (b *BaseLayer) TrainableParameters() int {
+	return countParameters(b.Parameters())
+}
+
+//SetTrainable freezes the layer when trainable is false: optimizers then leave its weights, and the parameters of the
+//layers it tracks, unchanged. Their values are still saved in checkpoints. Call it with true to train them again.
+func (b *BaseLayer) SetTrainable(trainable bool) {
+	b.trainable = trainable
+	for _, w := range b.weights {
+		w.frozen = !trainable
+	}
+	setTrainable(b.layers, trainable)
+}
+
+//Trainable reports whether the layer is trainable, which it is unless it was frozen with SetTrainable.
+func (b *BaseLayer) Trainable() bool {
+	return b.trainable
+}
+
+//setTrainable freezes or unfreezes the layers that support it.
+func setTrainable(layers []Layer, trainable bool) {
+	for _, l := range layers {
+		if f, ok := l.(interface{ SetTrainable(bool) }); ok {
+			f.SetTrainable(trainable)
+		}
+	}
+}
@@ -3,43 +3,85 @@ package neuralnetwork
 import (
 	"encoding/csv"
 	"fmt"
+	"math"
 	"os"
+	"strings"
 )
 
-//Callback interface
+//Callback interface. Model.Fit calls OnEpochEnd after every epoch with that epoch's logs: "loss", every compiled
+//metric by name and, when validation data is given, the same values on the validation data prefixed with "val_".
+//Returning an error aborts the training.
 type Callback interface {
-	Do(a ...interface{})
+	OnEpochEnd(m *Model, epoch int, logs map[string]float64) error
+}
+
+//monitorMode returns "min" or "max" for mode, resolving "auto" from the monitored name like keras does.
+func monitorMode(mode, monitor string) string {
+	if mode == "min" || mode == "max" {
+		return mode
+	}
+	if strings.Contains(monitor, "acc") || strings.Contains(monitor, "score") {
+		return "max"
+	}
+	return "min"
+}
+
+//improved reports whether current is better than best by more than minDelta.
+func improved(mode string, current, best, minDelta float64) bool {
+	if mode == "max" {
+		return current > best+minDelta
+	}
+	return current < best-minDelta
+}
+
+func worst(mode string) float64 {
+	if mode == "max" {
+		return math.Inf(-1)
+	}
+	return math.Inf(1)
+}
+
+//monitored returns the value of monitor in logs.
+func monitored(logs map[string]float64, monitor string) (float64, error) {
+	v, ok := logs[monitor]
+	if !ok {
+		return 0, fmt.Errorf("monitored value %q is not in the training logs", monitor)
+	}
+	return v, nil
 }
 
 //EarlyStopper callback
 type EarlyStopper struct {
-	index    int
+	monitor  string
 	patience int
 	minDelta float64
 	mode     string
+	best     float64
+	wait     int
 }
 
-//EarlyStopping is much like the keras EarlyStopping callback. Index is the number in the model metrics attribute. Look it up under model.get_metrics()
-func (m *Model) EarlyStopping(index int, patience int, at int, minDdelta float64, mode string) {
-	metrics := m.GetMetricsByIndex(index)
-	values := make([]float64, at)
-	for i := 0; i < at; i++ {
-		val := metrics.Measure(m.Predict(m.trainDataX), m.trainDataY)
-		values = append(values, val)
+//EarlyStopping is much like the keras EarlyStopping callback. Monitor names a log entry such as "val_loss"; training stops once it
+//has not improved by more than minDelta for patience epochs. Mode is "min", "max" or "auto".
+func EarlyStopping(monitor string, patience int, minDelta float64, mode string) *EarlyStopper {
+	mode = monitorMode(mode, monitor)
+	return &EarlyStopper{monitor: monitor, patience: patience, minDelta: minDelta, mode: mode, best: worst(mode)}
+}
+
+//OnEpochEnd stops the training when the monitored value stopped improving.
+func (es *EarlyStopper) OnEpochEnd(m *Model, epoch int, logs map[string]float64) error {
+	val, err := monitored(logs, es.monitor)
+	if err != nil {
+		return err
 	}
-	for j := range values {
-		mind := values[j] - values[j+patience]
-		maxd := values[j+patience] - values[j]
-		if mode == "auto" && (mind < minDdelta || maxd > minDdelta) {
-			m.training = false
-		}
-		if mode == "min" && mind < minDdelta {
-			m.training = false
-		}
-		if mode == "max" && maxd > minDdelta {
-			m.training = false
-		}
+	if improved(es.mode, val, es.best, es.minDelta) {
+		es.best, es.wait = val, 0
+		return nil
+	}
+	es.wait++
+	if es.wait >= es.patience {
+		m.training = false
 	}
+	return nil
 }
 
 //CsvLogger logger
@@ -48,26 +90,42 @@ func (m *Model) CsvLogger(filename string) error {
 	if err != nil {
 		return fmt.Errorf("could not create file with path %s:%v", filename, err)
 	}
+	defer f.Close()
 	writer := csv.NewWriter(f)
 	writer.Write(m.trainingLog.logs)
-	return nil
+	writer.Flush()
+	return writer.Error()
 }
 
-//ModelCheckpoint callback
-func (m *Model) ModelCheckpoint(filepath string, metrics Metrics, saveWeightsOnly bool) error {
-	f, err := os.Create(filepath)
+//Checkpointer callback
+type Checkpointer struct {
+	filepath        string
+	monitor         string
+	mode            string
+	saveBestOnly    bool
+	saveWeightsOnly bool
+	best            float64
+}
+
+//ModelCheckpoint callback saves a checkpoint of the model to filepath after every epoch, or only when the monitored value
+//improved if saveBestOnly is set. Unless saveWeightsOnly is set the optimizer state is saved as well, so that training
+//can be resumed from the file with LoadCheckpoint.
+func ModelCheckpoint(filepath, monitor string, saveBestOnly, saveWeightsOnly bool) *Checkpointer {
+	mode := monitorMode("auto", monitor)
+	return &Checkpointer{filepath: filepath, monitor: monitor, mode: mode, saveBestOnly: saveBestOnly, saveWeightsOnly: saveWeightsOnly, best: worst(mode)}
+}
+
+//OnEpochEnd saves the model.
+func (c *Checkpointer) OnEpochEnd(m *Model, epoch int, logs map[string]float64) error {
+	val, err := monitored(logs, c.monitor)
 	if err != nil {
-		return fmt.Errorf("could not create file with path %s:%v", filepath, err)
+		return err
 	}
-	for _, l := range m.layers {
-		s := fmt.Sprintf("%f", l.GetWeights().ToArray())
-		f.WriteString(s)
+	if c.saveBestOnly && !improved(c.mode, val, c.best, 0) {
+		return nil
 	}
-	if !saveWeightsOnly {
-		s := fmt.Sprintf("%f", metrics.Measure(m.Predict(m.trainDataX), m.trainDataY))
-		f.WriteString(s)
-	}
-	return nil
+	c.best = val
+	return m.SaveCheckpoint(c.filepath, c.saveWeightsOnly)
 }
 
 //CallbackList returns model's callback list
@@ -75,9 +133,26 @@ func (m *Model) CallbackList() []Callback {
 	return m.callbacks
 }
 
-//LearningRateScheduler defined by fn
+//LearningRateScheduler defined by fn sets the optimizer's learning rate to fn of the current one.
 func (m *Model) LearningRateScheduler(fn func(x float64) float64) {
-	m.learningRate = fn(m.learningRate)
+	m.optimizer.SetLearningRate(fn(m.optimizer.LearningRate()))
+}
+
+//Scheduler callback
+type Scheduler struct {
+	schedule func(epoch int, lr float64) float64
+}
+
+//LearningRateSchedule returns a callback that sets the learning rate to schedule(epoch, lr) after every epoch,
+//so the next epoch trains with the new rate.
+func LearningRateSchedule(schedule func(epoch int, lr float64) float64) *Scheduler {
+	return &Scheduler{schedule: schedule}
+}
+
+//OnEpochEnd updates the learning rate.
+func (s *Scheduler) OnEpochEnd(m *Model, epoch int, logs map[string]float64) error {
+	m.optimizer.SetLearningRate(s.schedule(epoch, m.optimizer.LearningRate()))
+	return nil
 }
 
 //CallbackHistory struct
@@ -92,7 +167,10 @@ func (m *Model) History(filepath string) (*CallbackHistory, error) {
 		return nil, fmt.Errorf("could not create file %s:%v", filepath, err)
 	}
 	for _, met := range m.modelMetrics {
-		val := met.Measure(m.Predict(m.trainDataX), m.trainDataY)
+		val, err := m.measure(met)
+		if err != nil {
+			return nil, err
+		}
 		s := fmt.Sprintf("%f\n", val)
 		f.WriteString(s)
 	}
@@ -110,40 +188,40 @@ func (ch *CallbackHistory) ReadToStrings() ([]string, error) {
 
 //ReduceLearningRateOnPlateau callback
 type ReduceLearningRateOnPlateau struct {
-	patience, index int
-	factor          float64
-	minDelta        float64
-	minimumLr       float64
-	mode            string
-	reducing        bool
-}
-
-//ReduceLr callback
-func (rlr *ReduceLearningRateOnPlateau) ReduceLr(m *Model, at int) {
-	metrics := m.GetMetricsByIndex(rlr.index)
-	values := make([]float64, at)
-	for i := 0; i < at; i++ {
-		val := metrics.Measure(m.Predict(m.trainDataX), m.trainDataY)
-		values = append(values, val)
-	}
-	for j := range values {
-		mind := values[j] - values[j+rlr.patience]
-		maxd := values[j+rlr.patience] - values[j]
-		if rlr.mode == "auto" && (mind < rlr.minDelta || maxd > rlr.minDelta) {
-			m.learningRate = m.learningRate * rlr.factor
-			rlr.reducing = true
-		}
-		if rlr.mode == "min" && mind < rlr.minDelta {
-			m.learningRate = m.learningRate * rlr.factor
-			rlr.reducing = true
+	monitor   string
+	patience  int
+	factor    float64
+	minDelta  float64
+	minimumLr float64
+	mode      string
+	reducing  bool
+	best      float64
+	wait      int
+}
 
-		}
-		if rlr.mode == "max" && maxd > rlr.minDelta {
-			m.learningRate = m.learningRate * rlr.factor
-			rlr.reducing = true
-		}
-		if m.learningRate < rlr.minimumLr {
-			m.learningRate = rlr.minimumLr * 100
-		}
+//ReduceLr returns a callback that multiplies the learning rate by factor once the monitored value, e.g. "val_loss",
+//has not improved by more than minDelta for patience epochs. The learning rate never drops below minimumLr.
+func ReduceLr(monitor string, factor float64, patience int, minDelta, minimumLr float64, mode string) *ReduceLearningRateOnPlateau {
+	mode = monitorMode(mode, monitor)
+	return &ReduceLearningRateOnPlateau{monitor: monitor, factor: factor, patience: patience, minDelta: minDelta, minimumLr: minimumLr, mode: mode, best: worst(mode)}
+}
+
+//OnEpochEnd reduces the learning rate on a plateau.
+func (rlr *ReduceLearningRateOnPlateau) OnEpochEnd(m *Model, epoch int, logs map[string]float64) error {
+	val, err := monitored(logs, rlr.monitor)
+	if err != nil {
+		return err
 	}
+	rlr.reducing = false
+	if improved(rlr.mode, val, rlr.best, rlr.minDelta) {
+		rlr.best, rlr.wait = val, 0
+		return nil
+	}
+	rlr.wait++
+	if rlr.wait >= rlr.patience {
+		m.optimizer.SetLearningRate(math.Max(m.optimizer.LearningRate()*rlr.factor, rlr.minimumLr))
+		rlr.reducing = true
+		rlr.wait = 0
+	}
+	return nil
 }
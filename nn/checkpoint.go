@@ -0,0 +1,102 @@
+package neuralnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//Checkpoint is what SaveCheckpoint writes: the value of every parameter in the order of Model.Parameters and, when
+//the model's optimizer implements StatefulOptimizer, its state.
+type Checkpoint struct {
+	Parameters []SavedTensor   `json:"parameters"`
+	Optimizer  *OptimizerState `json:"optimizer,omitempty"`
+}
+
+//SavedTensor is a tensor stored in a checkpoint.
+type SavedTensor struct {
+	Name  string    `json:"name"`
+	Shape []int     `json:"shape"`
+	Data  []float64 `json:"data"`
+}
+
+//Checkpoint returns the current parameters of the model and, unless weightsOnly is set, the state of its optimizer.
+func (m *Model) Checkpoint(weightsOnly bool) Checkpoint {
+	var c Checkpoint
+	for _, p := range m.Parameters() {
+		c.Parameters = append(c.Parameters, SavedTensor{Name: p.Name, Shape: p.Value.Shape(), Data: append([]float64(nil), p.Value.Data()...)})
+	}
+	if so, ok := m.optimizer.(StatefulOptimizer); ok && !weightsOnly {
+		state := so.State()
+		c.Optimizer = &state
+	}
+	return c
+}
+
+//SaveCheckpoint writes the model's checkpoint to path as JSON.
+func (m *Model) SaveCheckpoint(path string, weightsOnly bool) error {
+	b, err := json.Marshal(m.Checkpoint(weightsOnly))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("could not write checkpoint %s: %v", path, err)
+	}
+	return nil
+}
+
+//RestoreCheckpoint copies the parameters of c into the model and, if c holds an optimizer state, loads it into
+//the model's optimizer. The model must be built with the same layers, and compiled first if the optimizer is restored.
+//Parameters are matched by position, so a model whose layers got different names can load the checkpoint.
+func (m *Model) RestoreCheckpoint(c Checkpoint) error {
+	if err := m.autoBuild(); err != nil {
+		return err
+	}
+	params := m.Parameters()
+	if len(params) != len(c.Parameters) {
+		return fmt.Errorf("checkpoint has %d parameters, model %s has %d", len(c.Parameters), m.name, len(params))
+	}
+	renamed := make(map[string]string, len(params))
+	for i, p := range params {
+		saved := c.Parameters[i]
+		if !equalShapes(saved.Shape, p.Value.Shape()) || len(saved.Data) != p.Value.Size() {
+			return fmt.Errorf("parameter %s has shape %v in the checkpoint, want %v for %s", saved.Name, saved.Shape, p.Value.Shape(), p.Name)
+		}
+		renamed[saved.Name] = p.Name
+	}
+	for i, p := range params {
+		copy(p.Value.Data(), c.Parameters[i].Data)
+	}
+	if c.Optimizer == nil {
+		return nil
+	}
+	so, ok := m.optimizer.(StatefulOptimizer)
+	if !ok {
+		return fmt.Errorf("the optimizer of model %s cannot load the checkpoint's optimizer state", m.name)
+	}
+	state := *c.Optimizer
+	state.Slots = make(map[string]map[string][]float64, len(c.Optimizer.Slots))
+	for slot, values := range c.Optimizer.Slots {
+		state.Slots[slot] = make(map[string][]float64, len(values))
+		for name, v := range values {
+			if newName, ok := renamed[name]; ok {
+				name = newName
+			}
+			state.Slots[slot][name] = v
+		}
+	}
+	return so.LoadState(state)
+}
+
+//LoadCheckpoint reads a checkpoint written by SaveCheckpoint from path and restores it.
+func (m *Model) LoadCheckpoint(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read checkpoint %s: %v", path, err)
+	}
+	var c Checkpoint
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("could not decode checkpoint %s: %v", path, err)
+	}
+	return m.RestoreCheckpoint(c)
+}
@@ -0,0 +1,359 @@
+package neuralnetwork
+
+import (
+	"fmt"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//Padding decides how the borders of the inputs are handled by convolution and pooling layers.
+type Padding string
+
+const (
+	//Valid uses only the windows that fit entirely inside the input, so the output is smaller than the input.
+	Valid Padding = "valid"
+	//Same pads the input with zeros so that, with a stride of 1, the output has the size of the input.
+	Same Padding = "same"
+	//Causal pads the front of a sequence only, so that the output at step t depends on the inputs up to step t.
+	//It is only supported by Conv1D.
+	Causal Padding = "causal"
+)
+
+//DefaultPadding returns the padding used when none is specified, Valid, as in keras.
+func DefaultPadding() Padding {
+	return Valid
+}
+
+//window describes how a kernel slides over the spatial axes of an input: for every output position it lists the
+//input positions, flattened over the spatial axes, covered by every element of the kernel, or -1 for padding.
+type window struct {
+	inSize, outSize []int
+	inPositions     int
+	kernelPositions int
+	taps            []int //outPositions * kernelPositions entries
+}
+
+//newWindow computes the output size and the window table for spatial input sizes in. padBefore is the padding added
+//in front of every axis; the padding after it follows from the output size.
+func newWindow(in, kernel, strides, dilation, padBefore, out []int) *window {
+	w := &window{inSize: in, outSize: out, inPositions: 1, kernelPositions: 1}
+	for i := range in {
+		w.inPositions *= in[i]
+		w.kernelPositions *= kernel[i]
+	}
+	outPositions := 1
+	for _, o := range out {
+		outPositions *= o
+	}
+	w.taps = make([]int, 0, outPositions*w.kernelPositions)
+	outIdx := make([]int, len(out))
+	kIdx := make([]int, len(kernel))
+	for o := 0; o < outPositions; o++ {
+		unravel(o, out, outIdx)
+		for k := 0; k < w.kernelPositions; k++ {
+			unravel(k, kernel, kIdx)
+			pos := 0
+			for d := range in {
+				i := outIdx[d]*strides[d] + kIdx[d]*dilation[d] - padBefore[d]
+				if i < 0 || i >= in[d] {
+					pos = -1
+					break
+				}
+				pos = pos*in[d] + i
+			}
+			w.taps = append(w.taps, pos)
+		}
+	}
+	return w
+}
+
+//unravel writes the multi-index of the flat index i of an array of the given shape into idx.
+func unravel(i int, shape, idx []int) {
+	for d := len(shape) - 1; d >= 0; d-- {
+		idx[d] = i % shape[d]
+		i /= shape[d]
+	}
+}
+
+//outPositions returns the number of output positions of every sample.
+func (w *window) outPositions() int {
+	return len(w.taps) / w.kernelPositions
+}
+
+//im2col gathers the windows of x, a contiguous [batch, spatial..., channels] buffer, into a
+//[batch*outPositions, kernelPositions*channels] matrix.
+func (w *window) im2col(x []float64, batch, channels int) []float64 {
+	rowLen := w.kernelPositions * channels
+	cols := make([]float64, batch*w.outPositions()*rowLen)
+	for b := 0; b < batch; b++ {
+		sample := x[b*w.inPositions*channels:]
+		for o := 0; o < w.outPositions(); o++ {
+			row := cols[(b*w.outPositions()+o)*rowLen:]
+			for k, pos := range w.taps[o*w.kernelPositions : (o+1)*w.kernelPositions] {
+				if pos >= 0 {
+					copy(row[k*channels:(k+1)*channels], sample[pos*channels:(pos+1)*channels])
+				}
+			}
+		}
+	}
+	return cols
+}
+
+//col2im is the adjoint of im2col: it adds every entry of cols back to the input position it was gathered from.
+func (w *window) col2im(cols []float64, batch, channels int) []float64 {
+	rowLen := w.kernelPositions * channels
+	x := make([]float64, batch*w.inPositions*channels)
+	for b := 0; b < batch; b++ {
+		sample := x[b*w.inPositions*channels:]
+		for o := 0; o < w.outPositions(); o++ {
+			row := cols[(b*w.outPositions()+o)*rowLen:]
+			for k, pos := range w.taps[o*w.kernelPositions : (o+1)*w.kernelPositions] {
+				if pos < 0 {
+					continue
+				}
+				for c := 0; c < channels; c++ {
+					sample[pos*channels+c] += row[k*channels+c]
+				}
+			}
+		}
+	}
+	return x
+}
+
+//convPadding returns the output size of one axis and the padding in front of it.
+func convPadding(padding Padding, in, kernel, stride, dilation int) (out, before int, err error) {
+	extent := (kernel-1)*dilation + 1
+	switch padding {
+	case Valid:
+		if in < extent {
+			return 0, 0, fmt.Errorf("input size %d is smaller than the window of size %d", in, extent)
+		}
+		return (in-extent)/stride + 1, 0, nil
+	case Same:
+		out = (in + stride - 1) / stride
+		total := (out-1)*stride + extent - in
+		if total < 0 {
+			total = 0
+		}
+		return out, total / 2, nil
+	case Causal:
+		return (in-1)/stride + 1, extent - 1, nil
+	}
+	return 0, 0, fmt.Errorf("unknown padding %q", padding)
+}
+
+//convolution is the machinery shared by the convolution layers. It convolves inputs of shape
+//[batch, spatial..., channels] with a kernel of shape [kernel..., channels, filters].
+type convolution struct {
+	BaseLayer
+	rank          int
+	filters       int
+	kernel, bias  *Parameter
+	win           *window
+	inputShape    []int
+	cols          *tensor.Tensor
+	preActivation *tensor.Tensor
+	KernelSize    []int
+	Strides       []int
+	Dilation      []int
+	Padding       Padding
+	UseBias       bool
+	Activation    Activation
+	KernelInit    func(float64) float64
+	BiasInit      func(float64) float64
+}
+
+func newConvolution(rank, filters, kernelSize, stride int, padding Padding, name string) convolution {
+	c := convolution{BaseLayer: NewBaseLayer(name),
+		rank:       rank,
+		filters:    filters,
+		Padding:    padding,
+		UseBias:    true,
+		KernelInit: HeUniform,
+		BiasInit:   ZeroInitializer,
+	}
+	for i := 0; i < rank; i++ {
+		c.KernelSize = append(c.KernelSize, kernelSize)
+		c.Strides = append(c.Strides, stride)
+		c.Dilation = append(c.Dilation, 1)
+	}
+	return c
+}
+
+//layout validates the configuration against inputShape and returns the output sizes and the padding in front of
+//every spatial axis.
+func (c *convolution) layout(inputShape []int) (out, before []int, err error) {
+	if len(inputShape) != c.rank+1 {
+		return nil, nil, fmt.Errorf("%s: expected inputs of rank %d without the batch axis, got shape %v", c.name, c.rank+1, inputShape)
+	}
+	if len(c.KernelSize) != c.rank || len(c.Strides) != c.rank || len(c.Dilation) != c.rank {
+		return nil, nil, fmt.Errorf("%s: kernel size, strides and dilation must have %d values", c.name, c.rank)
+	}
+	if _, err := c.Activation.resolve(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	if c.Padding == Causal && c.rank != 1 {
+		return nil, nil, fmt.Errorf("%s: causal padding is only supported by 1D convolutions", c.name)
+	}
+	for i := 0; i < c.rank; i++ {
+		if c.KernelSize[i] < 1 || c.Strides[i] < 1 || c.Dilation[i] < 1 {
+			return nil, nil, fmt.Errorf("%s: kernel size, strides and dilation must be positive", c.name)
+		}
+		o, b, err := convPadding(c.Padding, inputShape[i], c.KernelSize[i], c.Strides[i], c.Dilation[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", c.name, err)
+		}
+		out, before = append(out, o), append(before, b)
+	}
+	return out, before, nil
+}
+
+//Build creates a kernel of shape [kernel..., channels, filters] where channels is the last axis of the input.
+func (c *convolution) Build(inputShape []int) ([]int, error) {
+	out, before, err := c.layout(inputShape)
+	if err != nil {
+		return nil, err
+	}
+	channels := inputShape[c.rank]
+	if c.kernel == nil {
+		shape := append(append([]int(nil), c.KernelSize...), channels, c.filters)
+		c.kernel = c.AddWeight("kernel", KernelWeight, shape, c.KernelInit, true)
+		if c.UseBias {
+			c.bias = c.AddWeight("bias", BiasWeight, []int{c.filters}, c.BiasInit, true)
+		}
+	} else if in := c.kernel.Value.Shape()[c.rank]; in != channels {
+		return nil, fmt.Errorf("%s: expected %d input channels, got shape %v", c.name, in, inputShape)
+	}
+	if c.win == nil || !equalShapes(c.win.inSize, inputShape[:c.rank]) {
+		c.win = newWindow(inputShape[:c.rank], c.KernelSize, c.Strides, c.Dilation, before, out)
+	}
+	return append(out, c.filters), nil
+}
+
+//Forward computes activation(convolution(inputs, kernel) + bias).
+func (c *convolution) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	outShape, err := c.Build(shape)
+	if err != nil {
+		return nil, err
+	}
+	batch, channels := inputs.Shape()[0], shape[c.rank]
+	cols, err := tensor.FromSlice(c.win.im2col(inputs.Data(), batch, channels), []int{batch * c.win.outPositions(), c.win.kernelPositions * channels})
+	if err != nil {
+		return nil, err
+	}
+	kernel, err := c.kernel.Value.Reshape(-1, c.filters)
+	if err != nil {
+		return nil, err
+	}
+	z, err := cols.MatMul(kernel)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	if c.bias != nil {
+		if z, err = z.Add(c.bias.Value); err != nil {
+			return nil, fmt.Errorf("%s: %v", c.name, err)
+		}
+	}
+	activation, _ := c.Activation.resolve()
+	c.inputShape, c.cols, c.preActivation = inputs.Shape(), cols, z
+	return z.Map(activation.Func).Reshape(withBatch(batch, outShape)...)
+}
+
+//Backward accumulates the kernel and bias gradients and returns the gradient with respect to the inputs.
+func (c *convolution) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if c.cols == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", c.name)
+	}
+	g, err := gradOutput.Reshape(-1, c.filters)
+	if err != nil {
+		return nil, err
+	}
+	activation, _ := c.Activation.resolve()
+	dz, err := g.Multiply(c.preActivation.Map(activation.Derivative))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", c.name, err)
+	}
+	colsT, err := c.cols.Transpose()
+	if err != nil {
+		return nil, err
+	}
+	kernelGrad, err := colsT.MatMul(dz)
+	if err != nil {
+		return nil, err
+	}
+	if kernelGrad, err = kernelGrad.Reshape(c.kernel.Value.Shape()...); err != nil {
+		return nil, err
+	}
+	if err := c.kernel.Accumulate(kernelGrad); err != nil {
+		return nil, err
+	}
+	if c.bias != nil {
+		biasGrad, err := dz.SumAxis(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.bias.Accumulate(biasGrad); err != nil {
+			return nil, err
+		}
+	}
+	kernel, err := c.kernel.Value.Reshape(-1, c.filters)
+	if err != nil {
+		return nil, err
+	}
+	kernelT, err := kernel.Transpose()
+	if err != nil {
+		return nil, err
+	}
+	dcols, err := dz.MatMul(kernelT)
+	if err != nil {
+		return nil, err
+	}
+	channels := c.inputShape[len(c.inputShape)-1]
+	return tensor.FromSlice(c.win.col2im(dcols.Data(), c.inputShape[0], channels), c.inputShape)
+}
+
+//GetWeights returns the layer's kernel.
+func (c *convolution) GetWeights() *tensor.Tensor {
+	if c.kernel == nil {
+		return nil
+	}
+	return c.kernel.Value
+}
+
+//GetBiases returns the layer's biases.
+func (c *convolution) GetBiases() *tensor.Tensor {
+	if c.bias == nil {
+		return nil
+	}
+	return c.bias.Value
+}
+
+//Conv2DLayer is a 2D convolution over inputs of shape [batch, height, width, channels]. Its kernel has shape
+//[kernelHeight, kernelWidth, channels, filters]. KernelSize, Strides and Dilation hold one value per spatial axis
+//and can be changed before the layer is built.
+type Conv2DLayer struct {
+	convolution
+}
+
+//Conv2D returns a 2D convolution layer with filters output channels, a square kernel of size kernelSize moving by
+//stride along both axes, no dilation, a bias and no activation.
+func Conv2D(filters, kernelSize, stride int, padding Padding) *Conv2DLayer {
+	return &Conv2DLayer{newConvolution(2, filters, kernelSize, stride, padding, "conv2d")}
+}
+
+//Conv1DLayer is a 1D convolution over sequences of shape [batch, steps, channels]. Its kernel has shape
+//[kernelSize, channels, filters]. With Causal padding and a Dilation above 1 it is the building block of temporal
+//convolution networks.
+type Conv1DLayer struct {
+	convolution
+}
+
+//Conv1D returns a 1D convolution layer with filters output channels and a kernel of size kernelSize moving by stride,
+//with no dilation, a bias and no activation.
+func Conv1D(filters, kernelSize, stride int, padding Padding) *Conv1DLayer {
+	return &Conv1DLayer{newConvolution(1, filters, kernelSize, stride, padding, "conv1d")}
+}
@@ -0,0 +1,162 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//selu constants: a dropped unit of AlphaDropout takes the value the SELU activation saturates to, -scale*alpha.
+const (
+	seluAlpha = 1.6732632423543772
+	seluScale = 1.0507009873554805
+)
+
+//dropout is the machinery shared by the dropout layers. While training it draws a Bernoulli mask keeping every unit
+//with probability 1-Rate and turns the inputs into inputs*scale + shift, element by element; at inference it lets the
+//inputs through untouched.
+type dropout struct {
+	BaseLayer
+	rng          *rand.Rand
+	channels     bool //draw one value per sample and channel, the last axis, instead of one per element
+	alpha        bool //keep the mean and variance of the inputs instead of scaling the kept units by 1/(1-Rate)
+	scale, shift []float64
+	inputShape   []int
+	Rate         float64
+}
+
+func newDropout(rate float64, name string) dropout {
+	return dropout{BaseLayer: NewBaseLayer(name), rng: rand.New(rand.NewSource(time.Now().UnixNano())), Rate: rate}
+}
+
+//SetSeed seeds the random generator of the masks: the same seed always drops the same units.
+func (d *dropout) SetSeed(seed int64) {
+	d.rng = rand.New(rand.NewSource(seed))
+}
+
+//Build validates the rate. The output has the shape of the input.
+func (d *dropout) Build(inputShape []int) ([]int, error) {
+	if d.Rate < 0 || d.Rate >= 1 {
+		return nil, fmt.Errorf("%s: rate must be in [0, 1), got %v", d.name, d.Rate)
+	}
+	if d.channels && len(inputShape) < 2 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [spatial..., channels] without the batch axis, got %v", d.name, inputShape)
+	}
+	return append([]int(nil), inputShape...), nil
+}
+
+//Forward drops units while training and passes the inputs through at inference.
+func (d *dropout) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", d.name, err)
+	}
+	if _, err := d.Build(shape); err != nil {
+		return nil, err
+	}
+	d.inputShape, d.scale, d.shift = inputs.Shape(), nil, nil
+	if !training || d.Rate == 0 {
+		return inputs, nil
+	}
+	size, group := inputs.Size(), 1
+	if d.channels {
+		group = shape[len(shape)-1]
+	}
+	keep := make([]bool, size)
+	if d.channels {
+		batch, inner := inputs.Shape()[0], size/inputs.Shape()[0]/group
+		for b := 0; b < batch; b++ {
+			for c := 0; c < group; c++ {
+				k := d.rng.Float64() >= d.Rate
+				for p := 0; p < inner; p++ {
+					keep[(b*inner+p)*group+c] = k
+				}
+			}
+		}
+	} else {
+		for i := range keep {
+			keep[i] = d.rng.Float64() >= d.Rate
+		}
+	}
+
+	kept, dropped := 1/(1-d.Rate), 0.0
+	var offset float64
+	if d.alpha {
+		saturated := -seluScale * seluAlpha
+		a := 1 / math.Sqrt((1-d.Rate)*(1+d.Rate*saturated*saturated))
+		kept, dropped, offset = a, a*saturated, -a*saturated*d.Rate
+	}
+	d.scale, d.shift = make([]float64, size), make([]float64, size)
+	x := inputs.Data()
+	out := make([]float64, size)
+	for i, k := range keep {
+		d.shift[i] = offset
+		if k {
+			d.scale[i] = kept
+		} else {
+			d.shift[i] += dropped
+		}
+		out[i] = x[i]*d.scale[i] + d.shift[i]
+	}
+	return tensor.FromSlice(out, d.inputShape)
+}
+
+//Backward lets the gradient through the kept units only, scaled like their inputs were.
+func (d *dropout) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if d.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", d.name)
+	}
+	if d.scale == nil {
+		return gradOutput, nil
+	}
+	g := gradOutput.Data()
+	if len(g) != len(d.scale) {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", d.name, gradOutput.Shape())
+	}
+	dx := make([]float64, len(g))
+	for i, v := range g {
+		dx[i] = v * d.scale[i]
+	}
+	return tensor.FromSlice(dx, d.inputShape)
+}
+
+//DropoutLayer zeroes every input with probability Rate while training and scales the kept ones by 1/(1-Rate), so
+//that their expected value does not change and nothing has to be done at inference.
+type DropoutLayer struct {
+	dropout
+}
+
+//Dropout returns a dropout layer dropping units with probability rate. Call SetSeed for reproducible masks.
+func Dropout(rate float64) *DropoutLayer {
+	return &DropoutLayer{newDropout(rate, "dropout")}
+}
+
+//SpatialDropoutLayer drops whole channels of inputs of shape [batch, spatial..., channels] while training. Neighbouring
+//positions of a feature map are strongly correlated, so dropping single elements of convolutional outputs barely
+//regularizes them.
+type SpatialDropoutLayer struct {
+	dropout
+}
+
+//SpatialDropout returns a layer dropping every channel of every sample with probability rate.
+func SpatialDropout(rate float64) *SpatialDropoutLayer {
+	d := newDropout(rate, "spatial_dropout")
+	d.channels = true
+	return &SpatialDropoutLayer{d}
+}
+
+//AlphaDropoutLayer is the dropout of self-normalizing networks: dropped units take the negative saturation value of
+//SELU rather than zero, and an affine transform keeps the mean and variance of the inputs.
+type AlphaDropoutLayer struct {
+	dropout
+}
+
+//AlphaDropout returns an alpha dropout layer dropping units with probability rate.
+func AlphaDropout(rate float64) *AlphaDropoutLayer {
+	d := newDropout(rate, "alpha_dropout")
+	d.alpha = true
+	return &AlphaDropoutLayer{d}
+}
@@ -0,0 +1,261 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//EmbeddingLayer maps integer token ids to trainable vectors. Its inputs hold the ids, stored as float64, in any shape
+//[batch, ...]; its outputs have shape [batch, ..., dim]. The gradient of the embedding matrix is sparse, so optimizers
+//only update the rows of the tokens seen in the batch.
+type EmbeddingLayer struct {
+	BaseLayer
+	vocabSize, dim int
+	embeddings     *Parameter
+	ids            []int
+	inputShape     []int
+	mask           *tensor.Tensor
+	//MaskZero marks the id 0 as padding: the layer then produces a mask that the following layer, such as a
+	//recurrent one, uses to skip those steps.
+	MaskZero       bool
+	EmbeddingsInit func(float64) float64
+}
+
+//Embedding returns an embedding layer for ids in [0, vocabSize) and vectors of dim values.
+func Embedding(vocabSize, dim int) *EmbeddingLayer {
+	return &EmbeddingLayer{BaseLayer: NewBaseLayer("embedding"),
+		vocabSize:      vocabSize,
+		dim:            dim,
+		EmbeddingsInit: HeUniform,
+	}
+}
+
+//Build creates the [vocabSize, dim] embedding matrix.
+func (e *EmbeddingLayer) Build(inputShape []int) ([]int, error) {
+	if e.embeddings == nil {
+		e.embeddings = e.AddWeight("embeddings", EmbeddingsWeight, []int{e.vocabSize, e.dim}, e.EmbeddingsInit, true)
+	}
+	return append(append([]int(nil), inputShape...), e.dim), nil
+}
+
+//Forward looks up the vector of every id.
+func (e *EmbeddingLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", e.name, err)
+	}
+	outShape, err := e.Build(shape)
+	if err != nil {
+		return nil, err
+	}
+	values := inputs.Data()
+	ids := make([]int, len(values))
+	for i, v := range values {
+		id := int(v)
+		if float64(id) != v || id < 0 || id >= e.vocabSize {
+			return nil, fmt.Errorf("%s: %v is not a token id in [0, %d)", e.name, v, e.vocabSize)
+		}
+		ids[i] = id
+	}
+	table := e.embeddings.Value.Data()
+	out := make([]float64, len(ids)*e.dim)
+	for i, id := range ids {
+		copy(out[i*e.dim:(i+1)*e.dim], table[id*e.dim:(id+1)*e.dim])
+	}
+	e.mask = nil
+	if e.MaskZero {
+		e.mask = inputs.Map(func(v float64) float64 {
+			if v == 0 {
+				return 0
+			}
+			return 1
+		})
+	}
+	e.ids, e.inputShape = ids, inputs.Shape()
+	return tensor.FromSlice(out, withBatch(inputs.Shape()[0], outShape))
+}
+
+//Backward adds the gradient of every output vector to the row of its token. Token ids have no gradient, so the
+//returned gradient is zero.
+func (e *EmbeddingLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if e.ids == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", e.name)
+	}
+	g := gradOutput.Data()
+	if len(g) != len(e.ids)*e.dim {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", e.name, gradOutput.Shape())
+	}
+	if e.trainable {
+		e.embeddings.accumulateRows(e.ids, g)
+	}
+	return tensor.Zeros(e.inputShape), nil
+}
+
+//Mask returns the padding mask of the last Forward call, or nil unless MaskZero is set.
+func (e *EmbeddingLayer) Mask() *tensor.Tensor {
+	return e.mask
+}
+
+//SetEmbeddings replaces the embedding matrix, for instance with pretrained vectors, which SetTrainable(false) keeps
+//unchanged during training. It must have shape [vocabSize, dim].
+func (e *EmbeddingLayer) SetEmbeddings(matrix *tensor.Tensor) error {
+	if shape := matrix.Shape(); !equalShapes(shape, []int{e.vocabSize, e.dim}) {
+		return fmt.Errorf("%s: embeddings must have shape [%d %d], got %v", e.name, e.vocabSize, e.dim, shape)
+	}
+	if e.embeddings == nil {
+		e.embeddings = e.AddWeight("embeddings", EmbeddingsWeight, []int{e.vocabSize, e.dim}, ZeroInitializer, true)
+	}
+	e.embeddings.Value = matrix.Contiguous()
+	e.embeddings.ZeroGrad()
+	return nil
+}
+
+//GetEmbeddings returns the embedding matrix, or nil if the layer has not been built yet.
+func (e *EmbeddingLayer) GetEmbeddings() *tensor.Tensor {
+	if e.embeddings == nil {
+		return nil
+	}
+	return e.embeddings.Value
+}
+
+//positionalEncoding adds a [time, features] encoding to every sample of inputs of shape [batch, time, features].
+//Masks pass through it unchanged.
+type positionalEncoding struct {
+	BaseLayer
+	mask       *tensor.Tensor
+	inputShape []int
+}
+
+//SetMask receives the padding mask of the previous layer.
+func (p *positionalEncoding) SetMask(mask *tensor.Tensor) {
+	p.mask = mask
+}
+
+//Mask passes the padding mask on to the next layer.
+func (p *positionalEncoding) Mask() *tensor.Tensor {
+	return p.mask
+}
+
+//SinusoidalPositionalEncodingLayer adds the fixed sine and cosine encodings of "Attention Is All You Need":
+//PE[t, 2i] = sin(t / 10000^(2i/features)) and PE[t, 2i+1] = cos(t / 10000^(2i/features)).
+type SinusoidalPositionalEncodingLayer struct {
+	positionalEncoding
+}
+
+//SinusoidalPositionalEncoding returns a layer adding sinusoidal positional encodings to its inputs. It has no parameters.
+func SinusoidalPositionalEncoding() *SinusoidalPositionalEncodingLayer {
+	return &SinusoidalPositionalEncodingLayer{positionalEncoding{BaseLayer: NewBaseLayer("sinusoidal_positional_encoding")}}
+}
+
+//Build validates the input shape.
+func (s *SinusoidalPositionalEncodingLayer) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != 2 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [time, features], got %v", s.name, inputShape)
+	}
+	return append([]int(nil), inputShape...), nil
+}
+
+//Forward adds the encodings.
+func (s *SinusoidalPositionalEncodingLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s.name, err)
+	}
+	if _, err := s.Build(shape); err != nil {
+		return nil, err
+	}
+	steps, features := shape[0], shape[1]
+	pe := make([]float64, steps*features)
+	for t := 0; t < steps; t++ {
+		for i := 0; i < features; i++ {
+			angle := float64(t) / math.Pow(10000, float64(i-i%2)/float64(features))
+			if i%2 == 0 {
+				pe[t*features+i] = math.Sin(angle)
+			} else {
+				pe[t*features+i] = math.Cos(angle)
+			}
+		}
+	}
+	encoding, err := tensor.FromSlice(pe, shape)
+	if err != nil {
+		return nil, err
+	}
+	s.inputShape = inputs.Shape()
+	return inputs.Add(encoding)
+}
+
+//Backward returns the gradient unchanged.
+func (s *SinusoidalPositionalEncodingLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if s.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", s.name)
+	}
+	return gradOutput, nil
+}
+
+//LearnedPositionalEncodingLayer adds a trainable vector per position to its inputs.
+type LearnedPositionalEncodingLayer struct {
+	positionalEncoding
+	maxLength      int
+	positions      *Parameter
+	EmbeddingsInit func(float64) float64
+}
+
+//LearnedPositionalEncoding returns a layer adding learned positional encodings to sequences of up to maxLength steps.
+func LearnedPositionalEncoding(maxLength int) *LearnedPositionalEncodingLayer {
+	return &LearnedPositionalEncodingLayer{positionalEncoding: positionalEncoding{BaseLayer: NewBaseLayer("learned_positional_encoding")},
+		maxLength:      maxLength,
+		EmbeddingsInit: HeUniform,
+	}
+}
+
+//Build creates the [maxLength, features] position embeddings.
+func (l *LearnedPositionalEncodingLayer) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != 2 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [time, features], got %v", l.name, inputShape)
+	}
+	if inputShape[0] > l.maxLength {
+		return nil, fmt.Errorf("%s: sequences of %d steps are longer than the maximum length %d", l.name, inputShape[0], l.maxLength)
+	}
+	if l.positions == nil {
+		l.positions = l.AddWeight("positions", EmbeddingsWeight, []int{l.maxLength, inputShape[1]}, l.EmbeddingsInit, true)
+	} else if features := l.positions.Value.Shape()[1]; features != inputShape[1] {
+		return nil, fmt.Errorf("%s: expected %d input features, got shape %v", l.name, features, inputShape)
+	}
+	return append([]int(nil), inputShape...), nil
+}
+
+//Forward adds the embeddings of the first positions.
+func (l *LearnedPositionalEncodingLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", l.name, err)
+	}
+	if _, err := l.Build(shape); err != nil {
+		return nil, err
+	}
+	encoding, err := l.positions.Value.Gather(0, shape[0], 0)
+	if err != nil {
+		return nil, err
+	}
+	l.inputShape = inputs.Shape()
+	return inputs.Add(encoding)
+}
+
+//Backward accumulates the gradient of the used positions and returns the gradient unchanged.
+func (l *LearnedPositionalEncodingLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if l.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", l.name)
+	}
+	sum, err := gradOutput.SumAxis(0)
+	if err != nil {
+		return nil, err
+	}
+	grad := tensor.Zeros(l.positions.Value.Shape())
+	copy(grad.Data(), sum.Data())
+	if err := l.positions.Accumulate(grad); err != nil {
+		return nil, err
+	}
+	return gradOutput, nil
+}
@@ -0,0 +1,479 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//MultiInputLayer is implemented by layers combining several inputs, like the merge layers or the recurrent layers
+//given initial states. Apply calls them with the outputs of all their input nodes; the Layer methods of the merge
+//layers only accept a single input and are not meant to be used.
+type MultiInputLayer interface {
+	BuildInputs(inputShapes [][]int) ([]int, error)
+	ForwardInputs(inputs []*tensor.Tensor, training bool) (*tensor.Tensor, error)
+	BackwardInputs(gradOutput *tensor.Tensor) ([]*tensor.Tensor, error)
+}
+
+//MultiOutputLayer is implemented by layers with outputs next to their main ones, like the recurrent layers with
+//ReturnState set, which also output their final states. Apply returns the node of the main outputs and the node's
+//ExtraOutputs the nodes of the others. ExtraOutputShapes gives their shapes, without the batch axis, once the layer
+//is built, and ExtraOutputs their values after a Forward call. The model hands the layer the gradients with respect
+//to them before every Backward call, nil for those nothing depends on.
+type MultiOutputLayer interface {
+	ExtraOutputShapes() [][]int
+	ExtraOutputs() []*tensor.Tensor
+	SetExtraGradients(grads []*tensor.Tensor)
+}
+
+//StateSaver is implemented by layers that save and restore what the backward pass of a call needs themselves. A model
+//calling a layer several times saves its state after every call and sets it back before the backward pass of that
+//call. Without State and SetState, the model copies the fields of the layer instead, which is only right if Forward
+//replaces the slices and tensors it keeps rather than writing into them, as the built-in layers do. The layers a
+//layer tracks are saved separately.
+type StateSaver interface {
+	State() interface{}
+	SetState(state interface{})
+}
+
+//Node is the symbolic output of a layer in a functional model: Apply creates it from the nodes of the layer's inputs
+//and Functional turns the graph of nodes into a model. Nothing is computed until the model runs.
+//A node whose layer rejected its inputs keeps the error, which every node built on it and Functional report.
+type Node struct {
+	layer   Layer
+	inbound []*Node
+	shape   []int
+	err     error
+	extra   []*Node //the nodes of the extra outputs of a MultiOutputLayer
+	source  *Node   //the node of the call an extra output comes from
+	index   int     //the position of an extra output
+}
+
+//Apply calls layer on the given nodes and returns the node of its outputs. Layers take exactly one input except for
+//a MultiInputLayer. The layer is built for the shapes of the inputs right away; an error is kept in the returned node.
+//The same layer can be applied several times, sharing its parameters between the calls.
+func Apply(layer Layer, inputs ...*Node) *Node {
+	n := &Node{layer: layer, inbound: inputs}
+	shapes := make([][]int, len(inputs))
+	for i, in := range inputs {
+		if in.err != nil {
+			n.err = in.err
+			return n
+		}
+		shapes[i] = in.shape
+	}
+	if _, ok := layer.(*InputLayer); ok {
+		n.err = fmt.Errorf("%s: input layers start a model, use their Output node", layer.Name())
+		return n
+	}
+	if m, ok := layer.(MultiInputLayer); ok {
+		n.shape, n.err = m.BuildInputs(shapes)
+		return n
+	}
+	if len(inputs) != 1 {
+		n.err = fmt.Errorf("%s: expected one input, got %d", layer.Name(), len(inputs))
+		return n
+	}
+	n.shape, n.err = layer.Build(shapes[0])
+	return n
+}
+
+//Shape returns the shape of one sample of the node, without the batch axis.
+func (n *Node) Shape() []int {
+	return append([]int(nil), n.shape...)
+}
+
+//Layer returns the layer producing the node.
+func (n *Node) Layer() Layer {
+	return n.layer
+}
+
+//ExtraOutputs returns the nodes of the outputs of a MultiOutputLayer next to n, like the final states of a recurrent
+//layer with ReturnState set, in the order of the layer's ExtraOutputShapes. They share the error of n and are named
+//"<layer name>:1", "<layer name>:2"... as model outputs. It returns nil for other layers.
+func (n *Node) ExtraOutputs() []*Node {
+	m, ok := n.layer.(MultiOutputLayer)
+	if !ok || n.source != nil {
+		return nil
+	}
+	if n.extra == nil {
+		for i, shape := range m.ExtraOutputShapes() {
+			n.extra = append(n.extra, &Node{layer: n.layer, inbound: []*Node{n}, shape: shape, err: n.err, source: n, index: i})
+		}
+	}
+	return n.extra
+}
+
+//name returns the name of the layer of n, followed by the position of an extra output.
+func (n *Node) name() string {
+	if n.source != nil {
+		return fmt.Sprintf("%s:%d", n.layer.Name(), n.index+1)
+	}
+	return n.layer.Name()
+}
+
+//Err returns the error building the node, or one of the nodes it depends on, ran into.
+func (n *Node) Err() error {
+	return n.err
+}
+
+//Output returns the node of the inputs, which Apply and Functional take.
+func (i *InputLayer) Output() *Node {
+	if i.node == nil {
+		i.node = &Node{layer: i, shape: i.Shape()}
+	}
+	return i.node
+}
+
+//Data holds tensors by the name of the model input or output they belong to.
+type Data map[string]*tensor.Tensor
+
+//graph runs the nodes between the inputs and the outputs of a model. It remembers the values of its last run for the
+//backward pass.
+type graph struct {
+	inputs, outputs []*Node
+	nodes           []*Node //every node but the inputs, in an order where inputs come before the nodes using them
+	consumers       map[*Node]int
+	layers          []Layer //distinct layers, in the order of their first node
+	values, masks   map[*Node]*tensor.Tensor
+	training        bool
+	shared          map[Layer]bool       //layers of several nodes
+	states          map[*Node]layerState //state of the layer of a shared node after its call, for its backward pass
+}
+
+//newGraph sorts the nodes the outputs depend on. Every one of them must lead back to the given inputs.
+func newGraph(inputs, outputs []*Node) (*graph, error) {
+	g := &graph{inputs: inputs, outputs: outputs, consumers: map[*Node]int{}}
+	isInput := map[*Node]bool{}
+	seenLayers := map[Layer]bool{}
+	for _, in := range inputs {
+		if _, ok := in.layer.(*InputLayer); (in.layer != nil && !ok) || len(in.inbound) != 0 {
+			return nil, fmt.Errorf("model inputs must be the Output nodes of input layers")
+		}
+		if isInput[in] {
+			return nil, fmt.Errorf("%s is given twice as a model input", in.layer.Name())
+		}
+		isInput[in] = true
+		if in.layer != nil {
+			g.layers = append(g.layers, in.layer)
+			seenLayers[in.layer] = true
+		}
+	}
+	visited := map[*Node]bool{}
+	var visit func(n *Node) error
+	visit = func(n *Node) error {
+		if n.err != nil {
+			return n.err
+		}
+		if visited[n] || isInput[n] {
+			return nil
+		}
+		visited[n] = true
+		if len(n.inbound) == 0 {
+			return fmt.Errorf("%s is not connected to the model inputs", n.name())
+		}
+		for _, in := range n.inbound {
+			g.consumers[in]++
+			if err := visit(in); err != nil {
+				return err
+			}
+		}
+		g.nodes = append(g.nodes, n)
+		if !seenLayers[n.layer] {
+			seenLayers[n.layer] = true
+			g.layers = append(g.layers, n.layer)
+		}
+		return nil
+	}
+	for _, out := range outputs {
+		if err := visit(out); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.findShared(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+//findShared finds the layers of several nodes, whose state is saved after every call.
+func (g *graph) findShared() error {
+	g.shared = map[Layer]bool{}
+	calls := map[Layer]int{}
+	for _, n := range g.nodes {
+		if n.source != nil {
+			continue
+		}
+		calls[n.layer]++
+		if calls[n.layer] == 2 {
+			if err := canSaveState(n.layer); err != nil {
+				return err
+			}
+			g.shared[n.layer] = true
+		}
+	}
+	return nil
+}
+
+//chain returns the graph of a sequential model, each layer taking the outputs of the previous one.
+func chain(layers []Layer) (*graph, error) {
+	in := &Node{}
+	g := &graph{inputs: []*Node{in}, consumers: map[*Node]int{}, layers: layers}
+	last := in
+	for _, l := range layers {
+		n := &Node{layer: l, inbound: []*Node{last}}
+		g.consumers[last]++
+		g.nodes = append(g.nodes, n)
+		last = n
+	}
+	g.outputs = []*Node{last}
+	return g, g.findShared()
+}
+
+//run computes the outputs for the given inputs, in the order of the graph's inputs and outputs.
+func (g *graph) run(inputs []*tensor.Tensor, training bool) ([]*tensor.Tensor, error) {
+	if len(inputs) != len(g.inputs) {
+		return nil, fmt.Errorf("expected %d inputs, got %d", len(g.inputs), len(inputs))
+	}
+	g.values, g.masks, g.training = map[*Node]*tensor.Tensor{}, map[*Node]*tensor.Tensor{}, training
+	g.states = map[*Node]layerState{}
+	for i, in := range g.inputs {
+		x := inputs[i]
+		if in.layer != nil {
+			var err error
+			if x, err = in.layer.Forward(x, training); err != nil {
+				return nil, err
+			}
+		}
+		g.values[in] = x
+	}
+	for _, n := range g.nodes {
+		if err := g.forward(n); err != nil {
+			return nil, err
+		}
+		if g.shared[n.layer] && n.source == nil {
+			g.states[n] = saveState(n.layer)
+		}
+	}
+	outputs := make([]*tensor.Tensor, len(g.outputs))
+	for i, out := range g.outputs {
+		outputs[i] = g.values[out]
+	}
+	return outputs, nil
+}
+
+//forward runs the layer of n. The mask of a MaskProducer is handed to the layer using its outputs, as its first input,
+//if that layer is a MaskConsumer, and passed on by the layers that are not MaskProducers as long as their outputs keep
+//the batch and time axes, like Dropout or Dense. The values of the extra outputs of n are set with those of n.
+func (g *graph) forward(n *Node) error {
+	if n.source != nil {
+		return nil
+	}
+	inputs := make([]*tensor.Tensor, len(n.inbound))
+	for i, in := range n.inbound {
+		inputs[i] = g.values[in]
+	}
+	if mc, ok := n.layer.(MaskConsumer); ok {
+		mc.SetMask(g.masks[n.inbound[0]])
+	}
+	var out *tensor.Tensor
+	var err error
+	if m, ok := n.layer.(MultiInputLayer); ok {
+		out, err = m.ForwardInputs(inputs, g.training)
+	} else {
+		out, err = n.layer.Forward(inputs[0], g.training)
+	}
+	if err != nil {
+		return err
+	}
+	g.values[n] = out
+	if m, ok := n.layer.(MultiOutputLayer); ok && len(n.extra) > 0 {
+		extra := m.ExtraOutputs()
+		if len(extra) != len(n.extra) {
+			return fmt.Errorf("%s: got %d extra outputs, want %d", n.layer.Name(), len(extra), len(n.extra))
+		}
+		for i, e := range n.extra {
+			g.values[e] = extra[i]
+		}
+	}
+	delete(g.masks, n)
+	if mp, ok := n.layer.(MaskProducer); ok {
+		if mp.Mask() != nil {
+			g.masks[n] = mp.Mask()
+		}
+	} else if mask := g.masks[n.inbound[0]]; mask != nil && keepsSteps(out, mask) {
+		g.masks[n] = mask
+	}
+	return nil
+}
+
+//keepsSteps reports whether outputs of shape [batch, time, ...] match the [batch, time] mask.
+func keepsSteps(outputs, mask *tensor.Tensor) bool {
+	shape := outputs.Shape()
+	return len(shape) >= 3 && shape[0] == mask.Shape()[0] && shape[1] == mask.Shape()[1]
+}
+
+//backward propagates the given gradients, with respect to the values of nodes of the last run, down to the inputs.
+//Nodes no gradient reaches are skipped. Layers only keep what their last call needs for the backward pass, so a
+//shared layer is set back to the state saved after a call before the backward pass of that call, and to the state
+//of its last call at the end: running the call again would draw new dropout masks or update moving statistics.
+//The gradients of extra outputs are handed to the layer before the backward pass of the call they come from.
+func (g *graph) backward(grads map[*Node]*tensor.Tensor) error {
+	if g.values == nil {
+		return fmt.Errorf("backward pass before any forward pass")
+	}
+	lastCall, current := map[Layer]*Node{}, map[Layer]*Node{}
+	for _, n := range g.nodes {
+		if n.source == nil {
+			lastCall[n.layer], current[n.layer] = n, n
+		}
+	}
+	extraGrads := map[*Node][]*tensor.Tensor{}
+	defer func() {
+		for l, n := range current {
+			if n != lastCall[l] {
+				g.states[lastCall[l]].restore()
+			}
+		}
+	}()
+	for i := len(g.nodes) - 1; i >= 0; i-- {
+		n := g.nodes[i]
+		grad, ok := grads[n]
+		if !ok {
+			continue
+		}
+		if n.source != nil {
+			//the extra outputs come after their source, whose backward pass is still to come
+			if extraGrads[n.source] == nil {
+				extraGrads[n.source] = make([]*tensor.Tensor, len(n.source.extra))
+			}
+			extraGrads[n.source][n.index] = grad
+			if _, ok := grads[n.source]; !ok {
+				grads[n.source] = tensor.Zeros(g.values[n.source].Shape())
+			}
+			continue
+		}
+		if current[n.layer] != n {
+			g.states[n].restore()
+			current[n.layer] = n
+		}
+		if m, ok := n.layer.(MultiOutputLayer); ok {
+			m.SetExtraGradients(extraGrads[n])
+		}
+		var inputGrads []*tensor.Tensor
+		if m, ok := n.layer.(MultiInputLayer); ok {
+			var err error
+			if inputGrads, err = m.BackwardInputs(grad); err != nil {
+				return err
+			}
+		} else {
+			inputGrad, err := n.layer.Backward(grad)
+			if err != nil {
+				return err
+			}
+			inputGrads = []*tensor.Tensor{inputGrad}
+		}
+		for j, in := range n.inbound {
+			if err := addGradient(grads, in, inputGrads[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//layerState holds the states of a layer and of the layers it tracks: those a StateSaver returns, or copies of the
+//fields of the other layers.
+type layerState []struct {
+	saver         StateSaver
+	state         interface{}
+	layer, fields reflect.Value
+}
+
+//canSaveState checks that the state of l can be saved: l and the layers it tracks must be StateSavers or pointers to
+//structs.
+func canSaveState(l Layer) error {
+	_, saver := l.(StateSaver)
+	if v := reflect.ValueOf(l); !saver && (v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct) {
+		return fmt.Errorf("%s: a layer applied several times must implement StateSaver or be a pointer to a struct", l.Name())
+	}
+	if t, ok := l.(interface{ Layers() []Layer }); ok {
+		for _, sub := range t.Layers() {
+			if err := canSaveState(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//saveState saves the states of l and of the layers it tracks. The copies of the fields share the slices and pointers
+//of the layers, which the forward pass replaces rather than overwrites.
+func saveState(l Layer) layerState {
+	s := make(layerState, 1)
+	if saver, ok := l.(StateSaver); ok {
+		s[0].saver, s[0].state = saver, saver.State()
+	} else {
+		s[0].layer = reflect.ValueOf(l).Elem()
+		s[0].fields = reflect.New(s[0].layer.Type()).Elem()
+		s[0].fields.Set(s[0].layer)
+	}
+	if t, ok := l.(interface{ Layers() []Layer }); ok {
+		for _, sub := range t.Layers() {
+			s = append(s, saveState(sub)...)
+		}
+	}
+	return s
+}
+
+//restore sets the layers back to the saved fields.
+func (s layerState) restore() {
+	for _, f := range s {
+		if f.saver != nil {
+			f.saver.SetState(f.state)
+		} else {
+			f.layer.Set(f.fields)
+		}
+	}
+}
+
+//addGradient adds grad to the gradient of n, which sums the gradients of nodes used by several others.
+func addGradient(grads map[*Node]*tensor.Tensor, n *Node, grad *tensor.Tensor) error {
+	sum, ok := grads[n]
+	if !ok {
+		grads[n] = grad
+		return nil
+	}
+	sum, err := sum.Add(grad)
+	if err != nil {
+		return err
+	}
+	grads[n] = sum
+	return nil
+}
+
+//Functional returns a model computing outputs from inputs, the Output nodes of its Input layers. Its inputs and
+//outputs are named after their layers, which FitData, PredictData, EvaluateData and CompileOutputs refer to.
+func Functional(inputs, outputs []*Node, name string) (*Model, error) {
+	if len(inputs) == 0 || len(outputs) == 0 {
+		return nil, fmt.Errorf("model %s needs at least one input and one output", name)
+	}
+	for _, in := range inputs {
+		if in.layer == nil {
+			return nil, fmt.Errorf("model inputs must be the Output nodes of input layers")
+		}
+	}
+	g, err := newGraph(inputs, outputs)
+	if err != nil {
+		return nil, fmt.Errorf("model %s: %v", name, err)
+	}
+	seen := map[string]bool{}
+	for _, out := range outputs {
+		if seen[out.name()] {
+			return nil, fmt.Errorf("model %s: %s is given twice as an output", name, out.name())
+		}
+		seen[out.name()] = true
+	}
+	return &Model{layers: g.layers, name: name, net: g, functional: true}, nil
+}
@@ -1,171 +1,578 @@
 package neuralnetwork
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
-	"time"
+	"sort"
+	"sync"
 
-	"github.com/timothy102/matrix"
+	"github.com/timothy102/neuralnetwork/tensor"
 )
 
-//Layer interface given these 5 functions which every layer must have.
+//Layer interface given these functions which every layer must have.
+//Build creates the layer's parameters for inputs whose shape, without the batch axis, is inputShape and returns
+//the shape of the outputs; it is called lazily on the first Forward and may be called again, in which case it only
+//validates the shape. Forward runs the layer on a batch and remembers what Backward needs. Backward receives the gradient
+//of the loss with respect to the layer's output, accumulates the gradients of the layer's parameters and returns the
+//gradient with respect to its input. A layer applied several times in a model must implement StateSaver, or be a
+//pointer to a struct whose Forward replaces what it keeps rather than writing into it.
 type Layer interface {
-	Call() []float64
-	GetWeights() matrix.Matrix
-	GetBiases() matrix.Vector
+	Build(inputShape []int) ([]int, error)
+	Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error)
+	Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error)
+	Parameters() []*Parameter
 	Name() string
 	TrainableParameters() int
 }
 
-//DenseLayer defines a fully connected layer.
-type DenseLayer struct {
-	units             int
-	inputs, outputs   []float64
-	weights           Weights
-	biases            Biases
-	trainable         bool
-	name              string
-	kernelRegularizer func([]float64) []float64
-	biasRegularizer   func([]float64) []float64
-	Activation        func(float64) float64
-	KernelInit        func(float64) float64
-	BiasInit          func(float64) float64
+//MaskProducer is implemented by layers that can mark steps of their outputs as padding, like an Embedding with
+//MaskZero set. Mask returns a [batch, time] tensor of ones and zeros for the outputs of the last Forward call, zero
+//marking padding, or nil when every step is valid.
+type MaskProducer interface {
+	Mask() *tensor.Tensor
+}
+
+//MaskConsumer is implemented by layers that skip padding steps, like the recurrent layers. The model hands them the
+//mask of the previous layer before every Forward call; a nil mask means every step is valid. Layers in between that
+//keep the time axis, like Dropout or Dense, pass the mask on.
+type MaskConsumer interface {
+	SetMask(mask *tensor.Tensor)
+}
+
+//Parameter is a tensor the optimizer updates, together with the gradient accumulated for it by the backward pass.
+//Rows is set when the gradient is sparse, as it is for embeddings: only the listed rows, along the first axis, have a
+//gradient and only they should be updated. It is nil for dense gradients.
+//Parameters that are not Trainable, like the moving statistics of batch normalization, are saved with the model but
+//never given to the optimizer. Neither are the parameters of a frozen layer, see BaseLayer.SetTrainable.
+type Parameter struct {
+	Name      string
+	Value     *tensor.Tensor
+	Grad      *tensor.Tensor
+	Rows      []int
+	Trainable bool
+	touched   bool
+	frozen    bool
+}
+
+//NewParameter returns a trainable parameter holding value with a zero gradient.
+func NewParameter(name string, value *tensor.Tensor) *Parameter {
+	return &Parameter{Name: name, Value: value, Grad: tensor.Zeros(value.Shape()), Trainable: true}
+}
+
+//updatable reports whether optimizers update the parameter: it is trainable and its layer is not frozen.
+func (p *Parameter) updatable() bool {
+	return p.Trainable && !p.frozen
+}
+
+//ZeroGrad resets the accumulated gradient.
+func (p *Parameter) ZeroGrad() {
+	p.Grad = tensor.Zeros(p.Value.Shape())
+	p.Rows, p.touched = nil, false
+}
+
+//Accumulate adds g to the parameter's gradient, which makes it dense. Backward passes call it for every parameter.
+func (p *Parameter) Accumulate(g *tensor.Tensor) error {
+	sum, err := p.Grad.Add(g)
+	if err != nil {
+		return fmt.Errorf("gradient for %s: %v", p.Name, err)
+	}
+	p.Grad = sum
+	p.Rows, p.touched = nil, true
+	return nil
+}
+
+//accumulateRows adds g, which holds one gradient row per entry of rows, to the given rows of the parameter's gradient.
+//The gradient stays sparse unless a dense gradient was accumulated before.
+func (p *Parameter) accumulateRows(rows []int, g []float64) {
+	grad := p.Grad.Data()
+	rowLen := p.Value.Size() / p.Value.Shape()[0]
+	seen := make(map[int]bool, len(p.Rows))
+	for _, r := range p.Rows {
+		seen[r] = true
+	}
+	for i, r := range rows {
+		dst := grad[r*rowLen : (r+1)*rowLen]
+		for j, v := range g[i*rowLen : (i+1)*rowLen] {
+			dst[j] += v
+		}
+		if !seen[r] && (p.Rows != nil || !p.touched) {
+			seen[r] = true
+			p.Rows = append(p.Rows, r)
+		}
+	}
+	if !p.touched && p.Rows == nil {
+		p.Rows = []int{}
+	}
+	p.touched = true
+	sort.Ints(p.Rows)
+}
+
+//spans returns the [start, end) ranges of the flattened parameter that have a gradient: the whole parameter for a
+//dense gradient and the listed rows for a sparse one.
+func (p *Parameter) spans() [][2]int {
+	if p.Rows == nil {
+		return [][2]int{{0, p.Value.Size()}}
+	}
+	rowLen := p.Value.Size() / p.Value.Shape()[0]
+	spans := make([][2]int, len(p.Rows))
+	for i, r := range p.Rows {
+		spans[i] = [2]int{r * rowLen, (r + 1) * rowLen}
+	}
+	return spans
+}
+
+var (
+	layerNamesMu sync.Mutex
+	layerNames   = map[string]int{}
+)
+
+//uniqueName returns prefix for the first layer of a kind and prefix_n for the following ones, like keras does.
+func uniqueName(prefix string) string {
+	layerNamesMu.Lock()
+	defer layerNamesMu.Unlock()
+	n := layerNames[prefix]
+	layerNames[prefix]++
+	if n == 0 {
+		return prefix
+	}
+	return fmt.Sprintf("%s_%d", prefix, n)
+}
+
+//sampleShape returns the shape of t without the batch axis.
+func sampleShape(t *tensor.Tensor) ([]int, error) {
+	shape := t.Shape()
+	if len(shape) == 0 {
+		return nil, fmt.Errorf("expected a batch of samples, got a scalar")
+	}
+	return shape[1:], nil
+}
+
+//withBatch prepends the batch size to shape.
+func withBatch(batch int, shape []int) []int {
+	return append([]int{batch}, shape...)
 }
 
-//Weights struct with the actual kernels and the kernel initializer function.
-type Weights struct {
-	kernels    matrix.Matrix
-	KernelInit func(float64) float64
+//countParameters returns the number of values in params the optimizers update.
+func countParameters(params []*Parameter) int {
+	var n int
+	for _, p := range params {
+		if p.updatable() {
+			n += p.Value.Size()
+		}
+	}
+	return n
 }
 
-//Biases struct with the actual biases and the bias initializer function.
-type Biases struct {
-	bs       matrix.Vector
-	BiasInit func(float64) float64
+//countValues returns the number of values in params.
+func countValues(params []*Parameter) int {
+	var n int
+	for _, p := range params {
+		n += p.Value.Size()
+	}
+	return n
 }
 
-type shape struct {
-	inputShape []float64
+//DenseLayer defines a fully connected layer.
+type DenseLayer struct {
+	BaseLayer
+	units           int
+	inputs, outputs *tensor.Tensor
+	preActivation   *tensor.Tensor
+	kernel, bias    *Parameter
+	Activation      Activation
+	KernelInit      func(float64) float64
+	BiasInit        func(float64) float64
 }
 
-//WeightInit used for weight initialization. Already defined at the initialization of the dense layer.
-func WeightInit(a, b int, kernelInit func(float64) float64) Weights {
-	w := matrix.RandomValuedMatrix(a, b).MapFunc(kernelInit)
-	return Weights{kernels: w, KernelInit: kernelInit}
+//initTensor returns a tensor of the given shape whose elements are produced by init.
+func initTensor(shape []int, init func(float64) float64) *tensor.Tensor {
+	return tensor.Zeros(shape).Map(init)
 }
 
-//BiasInit used for bias initialization. Already defined at the initialization of the dense layer.
-func BiasInit(a int, biasInit func(float64) float64) Biases {
-	bs := matrix.RandomVector(a).Map(biasInit)
-	return Biases{bs: bs, BiasInit: biasInit}
+//Dense fully connected layer initializer. The kernel is created on the first call, once the number of input features is known.
+func Dense(units int, activation Activation) *DenseLayer {
+	return newDense(uniqueName("dense"), units, activation)
 }
 
-//Dense fully connected layer initializer
-func Dense(units int, inputs []float64, activation func(float64) float64) DenseLayer {
-	weights := WeightInit(units, len(inputs), HeUniform)
-	biases := BiasInit(units, ZeroInitializer)
-	return DenseLayer{units: units,
-		inputs:     inputs,
+//newDense returns a dense layer named name.
+func newDense(name string, units int, activation Activation) *DenseLayer {
+	return &DenseLayer{BaseLayer: newBaseLayer(name),
+		units:      units,
 		Activation: activation,
-		weights:    weights,
-		biases:     biases,
+		KernelInit: HeUniform,
+		BiasInit:   ZeroInitializer,
 	}
 }
 
-//Call of the dense layer.Outputs the next tensors.
-func (d DenseLayer) Call() []float64 {
-	vec := matrix.NewVector(d.inputs).ApplyMatrix(d.weights.kernels).Add(d.biases.bs)
-	return vec.Map(d.Activation).Slice()
+//Build creates a kernel of shape [features, units] where features is the last axis of the input.
+func (d *DenseLayer) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) == 0 {
+		return nil, fmt.Errorf("%s: expected inputs with at least one feature axis", d.name)
+	}
+	if _, err := d.Activation.resolve(); err != nil {
+		return nil, fmt.Errorf("%s: %v", d.name, err)
+	}
+	features := inputShape[len(inputShape)-1]
+	if d.kernel == nil {
+		d.kernel = d.AddWeight("kernel", KernelWeight, []int{features, d.units}, d.KernelInit, true)
+		d.bias = d.AddWeight("bias", BiasWeight, []int{d.units}, d.BiasInit, true)
+	} else if in := d.kernel.Value.Shape()[0]; in != features {
+		return nil, fmt.Errorf("%s: expected %d input features, got shape %v", d.name, in, inputShape)
+	}
+	out := append([]int(nil), inputShape[:len(inputShape)-1]...)
+	return append(out, d.units), nil
 }
 
-//Name of the dense layer
-func (d DenseLayer) Name() string {
-	return d.name
+//Forward of the dense layer. Computes activation(inputs x kernel + bias) over the last axis of the inputs.
+func (d *DenseLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", d.name, err)
+	}
+	outShape, err := d.Build(shape)
+	if err != nil {
+		return nil, err
+	}
+	x, err := inputs.Reshape(-1, d.kernel.Value.Shape()[0])
+	if err != nil {
+		return nil, err
+	}
+	z, err := x.MatMul(d.kernel.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", d.name, err)
+	}
+	if z, err = z.Add(d.bias.Value); err != nil {
+		return nil, fmt.Errorf("%s: %v", d.name, err)
+	}
+	activation, _ := d.Activation.resolve()
+	d.inputs, d.preActivation = x, z
+	d.outputs, err = z.Map(activation.Func).Reshape(withBatch(inputs.Shape()[0], outShape)...)
+	return d.outputs, err
+}
+
+//Backward of the dense layer. Accumulates the kernel and bias gradients and returns the gradient with respect to the inputs.
+func (d *DenseLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if d.inputs == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", d.name)
+	}
+	g, err := gradOutput.Reshape(-1, d.units)
+	if err != nil {
+		return nil, err
+	}
+	activation, _ := d.Activation.resolve()
+	dz, err := g.Multiply(d.preActivation.Map(activation.Derivative))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", d.name, err)
+	}
+	inputsT, err := d.inputs.Transpose()
+	if err != nil {
+		return nil, err
+	}
+	kernelGrad, err := inputsT.MatMul(dz)
+	if err != nil {
+		return nil, err
+	}
+	biasGrad, err := dz.SumAxis(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.kernel.Accumulate(kernelGrad); err != nil {
+		return nil, err
+	}
+	if err := d.bias.Accumulate(biasGrad); err != nil {
+		return nil, err
+	}
+	kernelT, err := d.kernel.Value.Transpose()
+	if err != nil {
+		return nil, err
+	}
+	gradInputs, err := dz.MatMul(kernelT)
+	if err != nil {
+		return nil, err
+	}
+	inShape := gradOutput.Shape()
+	inShape[len(inShape)-1] = d.kernel.Value.Shape()[0]
+	return gradInputs.Reshape(inShape...)
 }
 
 //GetWeights returns the layer's weights.
-func (d DenseLayer) GetWeights() matrix.Matrix {
-	return d.weights.kernels
+func (d *DenseLayer) GetWeights() *tensor.Tensor {
+	if d.kernel == nil {
+		return nil
+	}
+	return d.kernel.Value
 }
 
 //GetBiases returns the layer's biases.
-func (d DenseLayer) GetBiases() matrix.Vector {
-	return d.biases.bs
+func (d *DenseLayer) GetBiases() *tensor.Tensor {
+	if d.bias == nil {
+		return nil
+	}
+	return d.bias.Value
+}
+
+//SetWeights is used for manually defining the kernel, of shape [features, units]. It builds the layer if needed.
+func (d *DenseLayer) SetWeights(kernels *tensor.Tensor) error {
+	shape := kernels.Shape()
+	if len(shape) != 2 || shape[1] != d.units {
+		return fmt.Errorf("%s: kernel must have shape [features, %d], got %v", d.name, d.units, shape)
+	}
+	if _, err := d.Build(shape[:1]); err != nil {
+		return err
+	}
+	d.kernel.Value = kernels
+	d.kernel.ZeroGrad()
+	return nil
 }
 
-//TrainableParameters returns the count of trainable parameters.
-func (d DenseLayer) TrainableParameters() int {
-	return d.weights.kernels.NumberOfElements() + d.biases.bs.NumberOfElements()
+//SetBiases is used for manually defining the bias vector. The layer must have been built.
+func (d *DenseLayer) SetBiases(bs *tensor.Tensor) error {
+	if d.bias == nil {
+		return fmt.Errorf("%s: layer is not built yet", d.name)
+	}
+	if err := tensor.AssertionError(d.bias.Value, bs); err != nil {
+		return err
+	}
+	d.bias.Value = bs
+	d.bias.ZeroGrad()
+	return nil
 }
 
-//SetWeights is used for manually defining the weight matrix.
-func (d *DenseLayer) SetWeights(kernels matrix.Matrix) {
-	d.weights.kernels = kernels
+//InputLayer layer, much like the keras one. It declares the shape of one sample so the model can be built before it sees any data.
+type InputLayer struct {
+	BaseLayer
+	shape []int
+	node  *Node
 }
 
-//SetBiases is used for manually defining the bias vector.
-func (d *DenseLayer) SetBiases(bs matrix.Vector) {
-	d.biases.bs = bs
+//Input layer for samples of the given shape, without the batch axis.
+func Input(shape []int) *InputLayer {
+	return &InputLayer{BaseLayer: NewBaseLayer("input"), shape: append([]int(nil), shape...)}
 }
 
-//InputLayer layer, much like the keras one.
-type InputLayer struct {
-	inputs, outputs []float64
-	weights         Weights
-	biases          Biases
-	trainable       bool
-	name            string
+//Build checks the input shape against the declared one.
+func (i *InputLayer) Build(inputShape []int) ([]int, error) {
+	if !equalShapes(inputShape, i.shape) {
+		return nil, fmt.Errorf("%s: expected samples of shape %v, got %v", i.name, i.shape, inputShape)
+	}
+	return i.Shape(), nil
 }
 
-//Input layer
-func Input(inputs []float64) InputLayer {
-	weights := WeightInit(len(inputs), 1, HeUniform)
-	biases := BiasInit(len(inputs), ZeroInitializer)
-	return InputLayer{
-		inputs:  inputs,
-		weights: weights,
-		biases:  biases,
+//Shape returns the declared sample shape.
+func (i *InputLayer) Shape() []int {
+	return append([]int(nil), i.shape...)
+}
+
+//Forward of the input layer validates the inputs and passes them through.
+func (i *InputLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", i.name, err)
 	}
+	if _, err := i.Build(shape); err != nil {
+		return nil, err
+	}
+	return inputs, nil
+}
+
+//Backward of the input layer passes the gradient through.
+func (i *InputLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	return gradOutput, nil
 }
 
-//Call of the input layer
-func (i *InputLayer) Call() []float64 {
-	vec := matrix.NewVector(i.inputs).ApplyMatrix(i.weights.kernels).Add(i.biases.bs)
-	i.outputs = vec.Slice()
-	return vec.Slice()
+func equalShapes(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-//BatchNormLayer layer
+//BatchNormLayer normalizes every channel with the mean and variance of the batch while training, and with moving
+//averages of them at inference, then scales and shifts it with the learnable gamma and beta. A frozen layer, see
+//SetTrainable, always uses the moving averages and leaves them unchanged, as when fine-tuning a pretrained model.
 type BatchNormLayer struct {
-	inputs, outputs      []float64
-	beta, epsilon, alpha float64
-	trainable            bool
-	name                 string
+	BaseLayer
+	gamma, beta                *Parameter
+	movingMean, movingVariance *Parameter
+	xhat, invStd               []float64
+	inputShape                 []int
+	channels, inner            int
+	batchStats                 bool
+	//Axis is the channel axis of the inputs, batch axis included: -1, the default, for [batch, ..., channels] and 1
+	//for [batch, channels, height, width].
+	Axis     int
+	Momentum float64
+	Epsilon  float64
+	Center   bool
+	Scale    bool
+}
+
+//BatchNorm returns a batch normalization layer over the last axis with momentum 0.99 and epsilon 1e-3.
+func BatchNorm() *BatchNormLayer {
+	return &BatchNormLayer{BaseLayer: NewBaseLayer("batch_normalization"), Axis: -1, Momentum: 0.99, Epsilon: 1e-3, Center: true, Scale: true}
+}
+
+//axis returns the channel axis of inputs of the given rank, batch axis included.
+func (bn *BatchNormLayer) axis(rank int) (int, error) {
+	axis := bn.Axis
+	if axis < 0 {
+		axis += rank
+	}
+	if axis <= 0 || axis >= rank {
+		return 0, fmt.Errorf("%s: axis %d is not a feature axis of inputs of rank %d", bn.name, bn.Axis, rank)
+	}
+	return axis, nil
+}
+
+//Build creates gamma, beta and the moving statistics, one value per channel.
+func (bn *BatchNormLayer) Build(inputShape []int) ([]int, error) {
+	axis, err := bn.axis(len(inputShape) + 1)
+	if err != nil {
+		return nil, err
+	}
+	channels := inputShape[axis-1]
+	if bn.movingMean == nil {
+		if bn.Scale {
+			bn.gamma = bn.AddWeight("gamma", OtherWeight, []int{channels}, OnesInitializer, true)
+		}
+		if bn.Center {
+			bn.beta = bn.AddWeight("beta", OtherWeight, []int{channels}, ZeroInitializer, true)
+		}
+		bn.movingMean = bn.AddWeight("moving_mean", OtherWeight, []int{channels}, ZeroInitializer, false)
+		bn.movingVariance = bn.AddWeight("moving_variance", OtherWeight, []int{channels}, OnesInitializer, false)
+	} else if c := bn.movingMean.Value.Size(); c != channels {
+		return nil, fmt.Errorf("%s: expected %d channels, got shape %v", bn.name, c, inputShape)
+	}
+	return append([]int(nil), inputShape...), nil
 }
 
-//BatchNorm init
-func BatchNorm(inputs []float64) BatchNormLayer {
-	return BatchNormLayer{inputs: inputs}
+//Forward normalizes the inputs with the statistics of the batch while training, updating the moving averages, and
+//with the moving averages otherwise.
+func (bn *BatchNormLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", bn.name, err)
+	}
+	if _, err := bn.Build(shape); err != nil {
+		return nil, err
+	}
+	full := inputs.Shape()
+	axis, _ := bn.axis(len(full))
+	channels, inner := full[axis], 1
+	for _, s := range full[axis+1:] {
+		inner *= s
+	}
+	x := inputs.Data()
+	count := float64(len(x) / channels)
+	channel := func(i int) int { return i / inner % channels }
+
+	mean, variance := bn.movingMean.Value.Data(), bn.movingVariance.Value.Data()
+	training = training && bn.trainable
+	if training {
+		mean, variance = make([]float64, channels), make([]float64, channels)
+		for i, v := range x {
+			mean[channel(i)] += v
+		}
+		for c := range mean {
+			mean[c] /= count
+		}
+		for i, v := range x {
+			d := v - mean[channel(i)]
+			variance[channel(i)] += d * d
+		}
+		movingMean, movingVariance := bn.movingMean.Value.Data(), bn.movingVariance.Value.Data()
+		for c := range variance {
+			variance[c] /= count
+			movingMean[c] = bn.Momentum*movingMean[c] + (1-bn.Momentum)*mean[c]
+			movingVariance[c] = bn.Momentum*movingVariance[c] + (1-bn.Momentum)*variance[c]
+		}
+	}
+	bn.invStd = make([]float64, channels)
+	for c := range bn.invStd {
+		bn.invStd[c] = 1 / math.Sqrt(variance[c]+bn.Epsilon)
+	}
+	bn.xhat = make([]float64, len(x))
+	out := make([]float64, len(x))
+	for i, v := range x {
+		c := channel(i)
+		bn.xhat[i] = (v - mean[c]) * bn.invStd[c]
+		out[i] = bn.xhat[i]
+		if bn.gamma != nil {
+			out[i] *= bn.gamma.Value.Data()[c]
+		}
+		if bn.beta != nil {
+			out[i] += bn.beta.Value.Data()[c]
+		}
+	}
+	bn.inputShape, bn.channels, bn.inner, bn.batchStats = full, channels, inner, training
+	return tensor.FromSlice(out, full)
 }
 
-//Call for the batch normalization layer
-func (bn *BatchNormLayer) Call() []float64 {
-	outputs := make([]float64, len(bn.inputs))
-	variance := Variance(bn.inputs)
-	mean := meanValue(bn.inputs)
-	for _, x := range bn.inputs {
-		newX := (x - mean) / math.Sqrt(variance+bn.epsilon)
-		outputs = append(outputs, bn.alpha*newX+bn.beta)
+//Backward accumulates the gradients of gamma and beta and returns the gradient with respect to the inputs, taking
+//into account that the batch statistics depend on every input while training.
+func (bn *BatchNormLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if bn.xhat == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", bn.name)
+	}
+	g := gradOutput.Data()
+	if len(g) != len(bn.xhat) {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", bn.name, gradOutput.Shape())
+	}
+	channels, inner := bn.channels, bn.inner
+	channel := func(i int) int { return i / inner % channels }
+	gammaGrad, betaGrad := make([]float64, channels), make([]float64, channels)
+	dxhat := make([]float64, len(g))
+	for i, v := range g {
+		c := channel(i)
+		gammaGrad[c] += v * bn.xhat[i]
+		betaGrad[c] += v
+		dxhat[i] = v
+		if bn.gamma != nil {
+			dxhat[i] *= bn.gamma.Value.Data()[c]
+		}
+	}
+	if bn.gamma != nil {
+		if err := accumulateData(bn.gamma, gammaGrad); err != nil {
+			return nil, err
+		}
+	}
+	if bn.beta != nil {
+		if err := accumulateData(bn.beta, betaGrad); err != nil {
+			return nil, err
+		}
+	}
+	dx := make([]float64, len(g))
+	if !bn.batchStats {
+		for i, d := range dxhat {
+			dx[i] = d * bn.invStd[channel(i)]
+		}
+		return tensor.FromSlice(dx, bn.inputShape)
+	}
+	sum, dot := make([]float64, channels), make([]float64, channels)
+	for i, d := range dxhat {
+		sum[channel(i)] += d
+		dot[channel(i)] += d * bn.xhat[i]
+	}
+	count := float64(len(g) / channels)
+	for i, d := range dxhat {
+		c := channel(i)
+		dx[i] = bn.invStd[c] * (d - sum[c]/count - bn.xhat[i]*dot[c]/count)
 	}
-	bn.outputs = outputs
-	return outputs
+	return tensor.FromSlice(dx, bn.inputShape)
 }
 
 //Variance returns the variance
 func Variance(fls []float64) float64 {
 	var sum float64
+	mean := meanValue(fls)
 	for _, f := range fls {
-		sum += math.Pow(f-meanValue(fls), 2)
+		sum += math.Pow(f-mean, 2)
 	}
 	return sum / float64(len(fls))
 }
@@ -175,76 +582,141 @@ func meanValue(fls []float64) float64 {
 	return mean
 }
 
-//DropoutLayer layer
-type DropoutLayer struct {
-	inputs []float64
-	rate   float64
+//softmaxAxis returns the softmax, or its logarithm, of x seen as [outer, n, inner] over the middle axis. The largest
+//value of every slice is subtracted first so that no exponential overflows.
+func softmaxAxis(x []float64, outer, n, inner int, log bool) []float64 {
+	out := make([]float64, len(x))
+	for o := 0; o < outer; o++ {
+		for i := 0; i < inner; i++ {
+			base := o*n*inner + i
+			max := math.Inf(-1)
+			for k := 0; k < n; k++ {
+				max = math.Max(max, x[base+k*inner])
+			}
+			var sum float64
+			for k := 0; k < n; k++ {
+				sum += math.Exp(x[base+k*inner] - max)
+			}
+			logSum := max + math.Log(sum)
+			for k := 0; k < n; k++ {
+				if log {
+					out[base+k*inner] = x[base+k*inner] - logSum
+				} else {
+					out[base+k*inner] = math.Exp(x[base+k*inner] - logSum)
+				}
+			}
+		}
+	}
+	return out
 }
 
-//Dropout init
-func Dropout(inputs []float64, rate float64) DropoutLayer {
-	return DropoutLayer{inputs: inputs, rate: rate}
-
+//softmax is the machinery shared by the softmax layers: it normalizes the inputs over one axis.
+type softmax struct {
+	BaseLayer
+	log     bool
+	outputs *tensor.Tensor
+	//Axis is the axis the probabilities sum to one over, batch axis included: -1, the default, for the last one.
+	Axis int
 }
 
-//Call for the dropout layer
-func (dr *DropoutLayer) Call() []float64 {
-	weightCount := dr.rate * float64(len(dr.inputs))
-	for i := int(weightCount); i > 0; i-- {
-		if len(dr.inputs)%int(weightCount) == 0 {
-			dr.inputs[i] = 0
-		}
+//split returns the sizes of the inputs before, along and after the softmax axis.
+func (s *softmax) split(shape []int) (outer, n, inner int, err error) {
+	axis := s.Axis
+	if axis < 0 {
+		axis += len(shape)
 	}
-	return dr.inputs
+	if axis <= 0 || axis >= len(shape) {
+		return 0, 0, 0, fmt.Errorf("%s: axis %d is not a feature axis of inputs of shape %v", s.name, s.Axis, shape)
+	}
+	outer, inner = 1, 1
+	for _, v := range shape[:axis] {
+		outer *= v
+	}
+	for _, v := range shape[axis+1:] {
+		inner *= v
+	}
+	return outer, shape[axis], inner, nil
 }
 
-//SoftmaxLayer layer
-type SoftmaxLayer struct {
-	inputs, outputs []float64
-	classes         int
+//Build validates the axis. The output has the shape of the input.
+func (s *softmax) Build(inputShape []int) ([]int, error) {
+	if _, _, _, err := s.split(withBatch(1, inputShape)); err != nil {
+		return nil, err
+	}
+	return append([]int(nil), inputShape...), nil
 }
 
-//Softmax returns the softmax layer based on values.
-func Softmax(inputs []float64, classes int) SoftmaxLayer {
-	return SoftmaxLayer{inputs: inputs, classes: classes}
+//Forward normalizes the inputs over the axis.
+func (s *softmax) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	outer, n, inner, err := s.split(inputs.Shape())
+	if err != nil {
+		return nil, err
+	}
+	s.outputs, err = tensor.FromSlice(softmaxAxis(inputs.Data(), outer, n, inner, s.log), inputs.Shape())
+	return s.outputs, err
 }
 
-//Call of the softmax
-func (s *SoftmaxLayer) Call() []float64 {
-	sum := 0.0
-	preds := make([]float64, len(s.inputs))
-	for i, n := range s.inputs {
-		preds[i] -= math.Exp(n - findMax(s.inputs))
-		sum += preds[i]
+//Backward multiplies the gradient with the Jacobian of the softmax, g - p*sum(g) for the log-softmax.
+func (s *softmax) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if s.outputs == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", s.name)
 	}
-	for k := range preds {
-		preds[k] /= sum
+	shape := s.outputs.Shape()
+	outer, n, inner, _ := s.split(shape)
+	out, g := s.outputs.Data(), gradOutput.Data()
+	if len(g) != len(out) {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", s.name, gradOutput.Shape())
+	}
+	grad := make([]float64, len(out))
+	for o := 0; o < outer; o++ {
+		for i := 0; i < inner; i++ {
+			base := o*n*inner + i
+			var dot float64
+			for k := 0; k < n; k++ {
+				j := base + k*inner
+				if s.log {
+					dot += g[j]
+				} else {
+					dot += g[j] * out[j]
+				}
+			}
+			for k := 0; k < n; k++ {
+				j := base + k*inner
+				if s.log {
+					grad[j] = g[j] - math.Exp(out[j])*dot
+				} else {
+					grad[j] = out[j] * (g[j] - dot)
+				}
+			}
+		}
 	}
-	outputs := preds[:s.classes]
-	s.outputs = outputs
-	return outputs
+	return tensor.FromSlice(grad, shape)
+}
+
+//SoftmaxLayer turns scores into probabilities over an axis. As the last layer of a model compiled with
+//CategoricalCrossEntropy, the model skips its Jacobian and starts the backward pass from p - y.
+type SoftmaxLayer struct {
+	softmax
 }
 
-//FlattenLayer layer
-type FlattenLayer struct {
-	inputs, outputs []float64
-	name            string
-	trainable       bool
+//Softmax returns the softmax layer, applied over the last axis.
+func Softmax() *SoftmaxLayer {
+	return &SoftmaxLayer{softmax{BaseLayer: NewBaseLayer("softmax"), Axis: -1}}
 }
 
-//Call of the FlattenLayer
-func (f *FlattenLayer) Call() []float64 {
-	return f.outputs
+//LogSoftmaxLayer returns the logarithm of the softmax of its inputs, computed without taking the logarithm of
+//probabilities that may have underflowed to zero.
+type LogSoftmaxLayer struct {
+	softmax
 }
 
-//Flatten init.
-func Flatten(m matrix.Matrix) FlattenLayer {
-	return FlattenLayer{outputs: m.ToArray()}
+//LogSoftmax returns the log-softmax layer, applied over the last axis.
+func LogSoftmax() *LogSoftmaxLayer {
+	return &LogSoftmaxLayer{softmax{BaseLayer: NewBaseLayer("log_softmax"), log: true, Axis: -1}}
 }
 
 //HeUniform stands for He Initialization or the glorot_unifom for kernel_initialization.
 func HeUniform(x float64) float64 {
-	rand.Seed(time.Now().UnixNano())
 	down, upper := x-0.4, x+0.4
 	return down + rand.Float64()*(upper-down)
 }
@@ -0,0 +1,934 @@
+package neuralnetwork
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+func randomTensor(rng *rand.Rand, shape []int) *tensor.Tensor {
+	t := tensor.Zeros(shape)
+	d := t.Data()
+	for i := range d {
+		d[i] = rng.NormFloat64()
+	}
+	return t
+}
+
+//checkGradients compares the backward pass of l against central differences of sum(Forward(x) * r) for a random r.
+func checkGradients(t *testing.T, l Layer, x *tensor.Tensor, tol float64) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	out, err := l.Forward(x, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := randomTensor(rng, out.Shape())
+	objective := func() float64 {
+		out, err := l.Forward(x, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prod, _ := out.Multiply(r)
+		return prod.Sum()
+	}
+	for _, p := range l.Parameters() {
+		p.ZeroGrad()
+	}
+	objective()
+	gradX, err := l.Backward(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, values, analytic []float64) {
+		const eps = 1e-5
+		for i := range values {
+			orig := values[i]
+			values[i] = orig + eps
+			up := objective()
+			values[i] = orig - eps
+			down := objective()
+			values[i] = orig
+			numeric := (up - down) / (2 * eps)
+			if math.Abs(analytic[i]-numeric) > tol*math.Max(1, math.Abs(numeric)) {
+				t.Errorf("%s %s[%d]: backward %v, numeric %v", l.Name(), what, i, analytic[i], numeric)
+				return
+			}
+		}
+	}
+	check("input", x.Data(), gradX.Data())
+	for _, p := range l.Parameters() {
+		check(p.Name, p.Value.Data(), p.Grad.Data())
+	}
+}
+
+func TestLayerGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	tests := []struct {
+		layer Layer
+		shape []int
+	}{
+		{Dense(3, Tanh), []int{4, 5}},
+		{Dense(2, Swish), []int{2, 3, 4}},
+		{BatchNorm(), []int{3, 6}},
+		{BatchNorm(), []int{2, 3, 2, 3}},
+		{channelsFirst(BatchNorm()), []int{2, 3, 2, 2}},
+		{Softmax(), []int{3, 4}},
+		{softmaxOver(1, Softmax()), []int{2, 3, 4}},
+		{LogSoftmax(), []int{3, 4}},
+		{softmaxOver(1, LogSoftmax()), []int{2, 4, 2}},
+		{Flatten(), []int{2, 3, 2}},
+		{Reshape(-1, 2), []int{2, 3, 4}},
+		{Permute(3, 1, 2), []int{2, 3, 4, 2}},
+		{RepeatVector(3), []int{2, 4}},
+		{Conv2D(3, 3, 1, Valid), []int{2, 5, 4, 2}},
+		{conv2D(2, 2, 2, Same, 1, Tanh), []int{2, 5, 5, 3}},
+		{conv2D(2, 3, 1, Same, 2, Linear), []int{1, 6, 5, 1}},
+		{MaxPooling2D(2), []int{2, 5, 4, 3}},
+		{samePooling(MaxPooling2D(3).pooling), []int{1, 5, 5, 2}},
+		{AveragePooling2D(2), []int{2, 4, 5, 2}},
+		{samePooling(AveragePooling2D(3).pooling), []int{1, 5, 4, 2}},
+		{GlobalMaxPooling2D(), []int{2, 3, 4, 2}},
+		{GlobalAveragePooling2D(), []int{2, 3, 4, 2}},
+		{Conv1D(3, 2, 1, Valid), []int{2, 6, 2}},
+		{conv1D(2, 3, 2, Same, 1), []int{2, 7, 3}},
+		{conv1D(2, 2, 1, Causal, 3), []int{2, 8, 1}},
+		{MaxPooling1D(2), []int{2, 6, 3}},
+		{AveragePooling1D(3), []int{2, 7, 2}},
+		{GlobalMaxPooling1D(), []int{2, 5, 3}},
+		{GlobalAveragePooling1D(), []int{2, 5, 3}},
+		{SimpleRNN(3, Tanh), []int{2, 4, 2}},
+		{sequences(SimpleRNN(2, Relu)), []int{2, 3, 3}},
+		{LSTM(3), []int{2, 4, 2}},
+		{sequences(LSTM(2)), []int{3, 3, 2}},
+		{GRU(3), []int{2, 4, 2}},
+		{sequences(GRU(2)), []int{3, 3, 2}},
+		{LayerNormalization(), []int{2, 3, 4}},
+		{GroupNormalization(2), []int{2, 3, 3, 4}},
+		{GroupNormalization(3), []int{2, 5, 3}},
+		{InstanceNormalization(), []int{2, 3, 2, 3}},
+		{MultiHeadAttention(2, 3), []int{2, 4, 5}},
+		{causal(MultiHeadAttention(3, 2)), []int{2, 4, 3}},
+		{TransformerEncoder(2, 2, 6), []int{2, 3, 4}},
+		{SinusoidalPositionalEncoding(), []int{2, 3, 4}},
+		{LearnedPositionalEncoding(5), []int{2, 3, 4}},
+	}
+	for _, tt := range tests {
+		checkGradients(t, tt.layer, randomTensor(rng, tt.shape), 1e-4)
+	}
+	//padding steps in the middle and at both ends of the sequences
+	mask := mustTensor(t, [][]float64{{1, 0, 1, 1}, {0, 1, 1, 0}})
+	for _, l := range []Layer{SimpleRNN(2, Tanh), sequences(LSTM(2)), GRU(3), sequences(GRU(2)), MultiHeadAttention(2, 2), TransformerEncoder(1, 3, 4)} {
+		l.(MaskConsumer).SetMask(mask)
+		checkGradients(t, l, randomTensor(rng, []int{2, 4, 3}), 1e-4)
+	}
+}
+
+func leakyRelu(alpha float64) Activation {
+	derivative := func(x float64) float64 {
+		if x < 0 {
+			return alpha
+		}
+		return 1
+	}
+	return Activation{Name: "leaky_relu", Func: func(x float64) float64 { return math.Max(x, alpha*x) }, Derivative: derivative}
+}
+
+func TestActivations(t *testing.T) {
+	rng := rand.New(rand.NewSource(13))
+	//closures of the same function literal must keep their own derivatives
+	for _, alpha := range []float64{0.1, 0.3} {
+		checkGradients(t, Dense(3, leakyRelu(alpha)), randomTensor(rng, []int{4, 2}), 1e-4)
+	}
+	for _, a := range []Activation{Sigmoid, Tanh, Relu, Linear, Elu, Swish} {
+		checkGradients(t, Dense(2, a), randomTensor(rng, []int{3, 2}), 1e-4)
+	}
+	noDerivative := Activation{Name: "cube", Func: func(x float64) float64 { return x * x * x }}
+	for _, tt := range []struct {
+		layer Layer
+		shape []int
+	}{
+		{Dense(2, noDerivative), []int{1, 2}},
+		{conv2D(1, 1, 1, Valid, 1, noDerivative), []int{1, 2, 2, 1}},
+		{SimpleRNN(2, noDerivative), []int{1, 2, 2}},
+	} {
+		_, err := tt.layer.Forward(tensor.Zeros(tt.shape), false)
+		if err == nil || !strings.Contains(err.Error(), "no derivative") {
+			t.Errorf("%s: got error %v, want one for an activation without a derivative", tt.layer.Name(), err)
+		}
+	}
+}
+
+func TestLayerBuildsLazily(t *testing.T) {
+	d := Dense(3, Relu)
+	if d.TrainableParameters() != 0 {
+		t.Fatal("layer should not have parameters before it is built")
+	}
+	out, err := d.Forward(tensor.Zeros([]int{2, 5}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.Shape(); !equalShapes(got, []int{2, 3}) {
+		t.Errorf("output shape = %v, want [2 3]", got)
+	}
+	if d.TrainableParameters() != 5*3+3 {
+		t.Errorf("trainable parameters = %d, want 18", d.TrainableParameters())
+	}
+	if _, err := d.Forward(tensor.Zeros([]int{2, 4}), false); err == nil {
+		t.Error("expected an error for a different number of input features")
+	}
+}
+
+func conv2D(filters, kernelSize, stride int, padding Padding, dilation int, activation Activation) *Conv2DLayer {
+	c := Conv2D(filters, kernelSize, stride, padding)
+	c.Dilation = []int{dilation, dilation}
+	c.Activation = activation
+	return c
+}
+
+func TestConv2DMatchesDirectConvolution(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, tt := range []struct {
+		padding          Padding
+		stride, dilation int
+		outH, outW       int
+	}{
+		{Valid, 1, 1, 5, 4},
+		{Valid, 2, 1, 3, 2},
+		{Same, 1, 1, 7, 6},
+		{Same, 2, 2, 4, 3},
+	} {
+		c := conv2D(2, 3, tt.stride, tt.padding, tt.dilation, Linear)
+		x := randomTensor(rng, []int{2, 7, 6, 3})
+		out, err := c.Forward(x, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{2, tt.outH, tt.outW, 2}; !equalShapes(out.Shape(), want) {
+			t.Fatalf("%s stride %d: output shape %v, want %v", tt.padding, tt.stride, out.Shape(), want)
+		}
+		//padding in front of an axis is half of the total padding, rounded down
+		extent := 2*tt.dilation + 1
+		padH := ((tt.outH-1)*tt.stride + extent - 7) / 2
+		padW := ((tt.outW-1)*tt.stride + extent - 6) / 2
+		if tt.padding == Valid {
+			padH, padW = 0, 0
+		}
+		k := c.GetWeights()
+		for b := 0; b < 2; b++ {
+			for i := 0; i < tt.outH; i++ {
+				for j := 0; j < tt.outW; j++ {
+					for f := 0; f < 2; f++ {
+						want := c.GetBiases().At(f)
+						for ki := 0; ki < 3; ki++ {
+							for kj := 0; kj < 3; kj++ {
+								r, s := i*tt.stride+ki*tt.dilation-padH, j*tt.stride+kj*tt.dilation-padW
+								if r < 0 || r >= 7 || s < 0 || s >= 6 {
+									continue
+								}
+								for ch := 0; ch < 3; ch++ {
+									want += x.At(b, r, s, ch) * k.At(ki, kj, ch, f)
+								}
+							}
+						}
+						if got := out.At(b, i, j, f); math.Abs(got-want) > 1e-12 {
+							t.Fatalf("%s stride %d: output[%d %d %d %d] = %v, want %v", tt.padding, tt.stride, b, i, j, f, got, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func samePooling(p pooling) *pooling {
+	p.Strides = []int{2, 2}
+	p.Padding = Same
+	return &p
+}
+
+func TestPooling2D(t *testing.T) {
+	x := mustTensor(t, [][][][]float64{{
+		{{1}, {2}, {5}},
+		{{3}, {4}, {0}},
+		{{-1}, {6}, {2}},
+	}})
+	tests := []struct {
+		layer Layer
+		want  []float64
+	}{
+		{MaxPooling2D(2), []float64{4}},
+		{AveragePooling2D(2), []float64{2.5}},
+		//the windows at the border only average the elements inside the input
+		{samePooling(AveragePooling2D(2).pooling), []float64{2.5, 2.5, 2.5, 2}},
+		{samePooling(MaxPooling2D(2).pooling), []float64{4, 5, 6, 2}},
+		{GlobalMaxPooling2D(), []float64{6}},
+		{GlobalAveragePooling2D(), []float64{22.0 / 9}},
+	}
+	for _, tt := range tests {
+		out, err := tt.layer.Forward(x, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range out.Data() {
+			if math.Abs(v-tt.want[i]) > 1e-12 {
+				t.Errorf("%s: output %v, want %v", tt.layer.Name(), out.Data(), tt.want)
+				break
+			}
+		}
+	}
+	if _, err := MaxPooling2D(4).Forward(x, false); err == nil {
+		t.Error("expected an error for a window larger than the input")
+	}
+	for _, v := range []float64{math.NaN(), math.Inf(-1)} {
+		pool := MaxPooling2D(2)
+		in := tensor.Zeros([]int{1, 2, 2, 1}).Map(func(float64) float64 { return v })
+		out, err := pool.Forward(in, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grad, err := pool.Backward(tensor.Ones(out.Shape()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out.Data()[0]; got != v && !(math.IsNaN(v) && math.IsNaN(got)) || grad.Sum() != 1 {
+			t.Errorf("a window of %v pooled to %v with gradient %v", v, got, grad.Data())
+		}
+	}
+}
+
+func conv1D(filters, kernelSize, stride int, padding Padding, dilation int) *Conv1DLayer {
+	c := Conv1D(filters, kernelSize, stride, padding)
+	c.Dilation = []int{dilation}
+	return c
+}
+
+func TestCausalConv1DDoesNotSeeTheFuture(t *testing.T) {
+	c := conv1D(1, 2, 1, Causal, 2)
+	c.KernelInit = OnesInitializer
+	x := mustTensor(t, [][][]float64{{{1}, {2}, {3}, {4}, {5}}})
+	out, err := c.Forward(x, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//y[t] = x[t-2] + x[t], with zeros before the sequence
+	want := []float64{1, 2, 4, 6, 8}
+	if !equalShapes(out.Shape(), []int{1, 5, 1}) {
+		t.Fatalf("output shape = %v, want [1 5 1]", out.Shape())
+	}
+	for i, v := range out.Data() {
+		if v != want[i] {
+			t.Fatalf("output = %v, want %v", out.Data(), want)
+		}
+	}
+	if _, err := Conv2D(1, 2, 1, Causal).Forward(tensor.Zeros([]int{1, 3, 3, 1}), false); err == nil {
+		t.Error("expected an error for causal padding in two dimensions")
+	}
+}
+
+//sequences makes a recurrent layer return the output of every step.
+func sequences(l interface{}) Layer {
+	switch r := l.(type) {
+	case *SimpleRNNLayer:
+		r.ReturnSequences = true
+	case *LSTMLayer:
+		r.ReturnSequences = true
+	case *GRULayer:
+		r.ReturnSequences = true
+	}
+	return l.(Layer)
+}
+
+func TestStatefulRecurrentLayers(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	x := randomTensor(rng, []int{2, 6, 3})
+	first, _ := x.Gather(0, 3, 1)
+	second, _ := x.Gather(3, 6, 1)
+	for _, l := range []*recurrent{&LSTM(4).recurrent, &GRU(4).recurrent, &SimpleRNN(4, Tanh).recurrent} {
+		whole, err := l.Forward(x, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wholeStates := l.States()
+		l.Stateful = true
+		l.ResetStates()
+		if _, err := l.Forward(first.Contiguous(), true); err != nil {
+			t.Fatal(err)
+		}
+		split, err := l.Forward(second.Contiguous(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range whole.Data() {
+			if math.Abs(split.Data()[i]-v) > 1e-12 {
+				t.Fatalf("%s: stateful output %v, want %v", l.name, split.Data(), whole.Data())
+			}
+		}
+		if len(l.States()) != l.cell.stateCount() {
+			t.Fatalf("%s: %d states, want %d", l.name, len(l.States()), l.cell.stateCount())
+		}
+		for i, s := range l.States() {
+			if !equalShapes(s.Shape(), []int{2, 4}) {
+				t.Errorf("%s: state shape %v, want [2 4]", l.name, s.Shape())
+			}
+			for j, v := range s.Data() {
+				if math.Abs(wholeStates[i].Data()[j]-v) > 1e-12 {
+					t.Fatalf("%s: state %d differs", l.name, i)
+				}
+			}
+		}
+		//inference starts from the states of training and leaves them unchanged
+		again, err := l.Forward(second.Contiguous(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		once, err := l.Forward(second.Contiguous(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range once.Data() {
+			if again.Data()[i] != v {
+				t.Fatalf("%s: an inference pass moved the states", l.name)
+			}
+		}
+		if _, err := l.Forward(randomTensor(rng, []int{3, 2, 3}), false); err != nil {
+			t.Errorf("%s: inference on another batch size: %v", l.name, err)
+		}
+		if _, err := l.Forward(randomTensor(rng, []int{3, 2, 3}), true); err == nil {
+			t.Errorf("%s: expected an error for a different batch size in stateful mode", l.name)
+		}
+		l.ResetStates()
+		if _, err := l.Forward(randomTensor(rng, []int{3, 2, 3}), true); err != nil {
+			t.Errorf("%s: %v", l.name, err)
+		}
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	e := Embedding(5, 3)
+	ids := mustTensor(t, [][]float64{{1, 3, 1}, {4, 0, 0}})
+	out, err := e.Forward(ids, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalShapes(out.Shape(), []int{2, 3, 3}) {
+		t.Fatalf("output shape = %v, want [2 3 3]", out.Shape())
+	}
+	table := e.GetEmbeddings()
+	if out.At(0, 2, 1) != table.At(1, 1) || out.At(1, 0, 2) != table.At(4, 2) {
+		t.Error("outputs are not the rows of the embedding matrix")
+	}
+	if e.Mask() != nil {
+		t.Error("expected no mask without MaskZero")
+	}
+
+	p := e.Parameters()[0]
+	p.ZeroGrad()
+	if _, err := e.Backward(tensor.Ones(out.Shape())); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 3, 4}; len(p.Rows) != len(want) || p.Rows[0] != 0 || p.Rows[1] != 1 || p.Rows[2] != 3 || p.Rows[3] != 4 {
+		t.Errorf("sparse rows = %v, want %v", p.Rows, want)
+	}
+	if p.Grad.At(1, 0) != 2 || p.Grad.At(0, 2) != 2 || p.Grad.At(2, 0) != 0 {
+		t.Errorf("gradient rows = %v", p.Grad)
+	}
+	before := table.Clone()
+	Adam(0.1).ApplyGradients([]*Parameter{p})
+	for j := 0; j < 3; j++ {
+		if table.At(2, j) != before.At(2, j) {
+			t.Error("the optimizer changed the row of a token that was not in the batch")
+		}
+		if table.At(3, j) == before.At(3, j) {
+			t.Error("the optimizer did not change the row of a token in the batch")
+		}
+	}
+
+	if _, err := e.Forward(mustTensor(t, [][]float64{{5}}), false); err == nil {
+		t.Error("expected an error for an id outside the vocabulary")
+	}
+	if _, err := e.Forward(mustTensor(t, [][]float64{{1.5}}), false); err == nil {
+		t.Error("expected an error for a fractional id")
+	}
+}
+
+func TestPretrainedFrozenEmbedding(t *testing.T) {
+	e := Embedding(3, 2)
+	pretrained := mustTensor(t, [][]float64{{0, 0}, {1, 2}, {3, 4}})
+	if err := e.SetEmbeddings(pretrained); err != nil {
+		t.Fatal(err)
+	}
+	e.SetTrainable(false)
+	if e.TrainableParameters() != 0 {
+		t.Errorf("a frozen embedding has %d trainable parameters, want 0", e.TrainableParameters())
+	}
+	x := mustTensor(t, [][]float64{{1, 2}, {2, 0}})
+	y := mustTensor(t, [][]float64{{1}, {0}})
+	model := Sequential([]Layer{e, Flatten(), Dense(1, Linear)}, "frozen")
+	model.Compile(SGD(0.1), MeanSquaredError{}, nil)
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 3}); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{0, 0, 1, 2, 3, 4}
+	for i, v := range e.GetEmbeddings().Data() {
+		if v != want[i] {
+			t.Fatalf("frozen embeddings changed to %v", e.GetEmbeddings().Data())
+		}
+	}
+	if err := e.SetEmbeddings(tensor.Zeros([]int{2, 2})); err == nil {
+		t.Error("expected an error for a matrix of the wrong shape")
+	}
+}
+
+func causal(a *MultiHeadAttentionLayer) *MultiHeadAttentionLayer {
+	a.Causal = true
+	return a
+}
+
+func TestAttentionMasks(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	x := randomTensor(rng, []int{1, 4, 3})
+	a := causal(MultiHeadAttention(2, 2))
+	a.SetMask(mustTensor(t, [][]float64{{1, 1, 0, 1}}))
+	if _, err := a.Forward(x, false); err != nil {
+		t.Fatal(err)
+	}
+	scores := a.AttentionScores()
+	if !equalShapes(scores.Shape(), []int{1, 2, 4, 4}) {
+		t.Fatalf("scores shape = %v, want [1 2 4 4]", scores.Shape())
+	}
+	for h := 0; h < 2; h++ {
+		for i := 0; i < 4; i++ {
+			var sum float64
+			for j := 0; j < 4; j++ {
+				w := scores.At(0, h, i, j)
+				if (j > i || j == 2) && w != 0 {
+					t.Errorf("head %d: step %d attends to step %d", h, i, j)
+				}
+				sum += w
+			}
+			if math.Abs(sum-1) > 1e-12 {
+				t.Errorf("head %d: weights of step %d sum to %v", h, i, sum)
+			}
+		}
+	}
+}
+
+func TestGroupNormalization(t *testing.T) {
+	rng := rand.New(rand.NewSource(13))
+	x := randomTensor(rng, []int{2, 3, 3, 4})
+	for _, tt := range []struct {
+		layer  Layer
+		groups int
+	}{
+		{GroupNormalization(2), 2},
+		{InstanceNormalization(), 4},
+		{GroupNormalization(1), 1},
+	} {
+		out, err := tt.layer.Forward(x, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := 4 / tt.groups
+		for b := 0; b < 2; b++ {
+			for g := 0; g < tt.groups; g++ {
+				var sum, sq float64
+				for i := 0; i < 9; i++ {
+					for c := g * size; c < (g+1)*size; c++ {
+						v := out.Data()[(b*9+i)*4+c]
+						sum += v
+						sq += v * v
+					}
+				}
+				n := float64(9 * size)
+				if math.Abs(sum/n) > 1e-12 || math.Abs(sq/n-1) > 1e-2 {
+					t.Errorf("%s: group %d of sample %d has mean %v and variance %v", tt.layer.Name(), g, b, sum/n, sq/n)
+				}
+			}
+		}
+	}
+	if _, err := GroupNormalization(3).Forward(x, false); err == nil {
+		t.Error("expected an error for 4 channels in 3 groups")
+	}
+}
+
+func TestLayerNormalization(t *testing.T) {
+	ln := LayerNormalization()
+	out, err := ln.Forward(mustTensor(t, [][]float64{{1, 2, 3, 6}, {-1, -1, 1, 1}}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		row, _ := out.Index(i)
+		mean := row.Sum() / 4
+		variance := row.Square().Sum() / 4
+		if math.Abs(mean) > 1e-12 || math.Abs(variance-1) > 1e-3 {
+			t.Errorf("row %d has mean %v and variance %v", i, mean, variance)
+		}
+	}
+}
+
+func channelsFirst(bn *BatchNormLayer) *BatchNormLayer {
+	bn.Axis = 1
+	return bn
+}
+
+func TestBatchNormStatistics(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	bn := channelsFirst(BatchNorm())
+	bn.Momentum = 0.5
+	x := randomTensor(rng, []int{4, 2, 3, 3})
+	//shift and scale the second channel
+	d := x.Data()
+	for i := range d {
+		if i/9%2 == 1 {
+			d[i] = 5 + 3*d[i]
+		}
+	}
+	out, err := bn.Forward(x, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c := 0; c < 2; c++ {
+		var sum, sq float64
+		for i, v := range out.Data() {
+			if i/9%2 == c {
+				sum += v
+				sq += v * v
+			}
+		}
+		if math.Abs(sum/36) > 1e-12 || math.Abs(sq/36-1) > 1e-2 {
+			t.Errorf("channel %d has mean %v and variance %v after normalization", c, sum/36, sq/36)
+		}
+	}
+	if bn.TrainableParameters() != 4 || len(bn.Parameters()) != 4 {
+		t.Errorf("got %d trainable values in %d parameters, want 4 in 4", bn.TrainableParameters(), len(bn.Parameters()))
+	}
+
+	for i := 0; i < 30; i++ {
+		if _, err := bn.Forward(x, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//with converged moving statistics inference normalizes like training does
+	inference, err := bn.Forward(x, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range inference.Data() {
+		if math.Abs(v-out.Data()[i]) > 1e-6 {
+			t.Fatalf("inference output %v differs from the training output %v", v, out.Data()[i])
+		}
+	}
+	checkGradients(t, bn, x, 1e-4)
+
+	if _, err := channelsFirst(BatchNorm()).Forward(tensor.Zeros([]int{2}), false); err == nil {
+		t.Error("expected an error for inputs without a channel axis")
+	}
+}
+
+func TestDropout(t *testing.T) {
+	rng := rand.New(rand.NewSource(17))
+	x := randomTensor(rng, []int{50, 20, 8})
+	for _, tt := range []struct {
+		layer interface {
+			Layer
+			SetSeed(int64)
+		}
+		channels bool
+	}{
+		{Dropout(0.3), false},
+		{SpatialDropout(0.3), true},
+	} {
+		tt.layer.SetSeed(5)
+		out, err := tt.layer.Forward(x, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grad, err := tt.layer.Backward(tensor.Ones(x.Shape()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dropped int
+		for i, v := range out.Data() {
+			switch {
+			case v == 0:
+				dropped++
+				if grad.Data()[i] != 0 {
+					t.Fatalf("%s: dropped unit %d has gradient %v", tt.layer.Name(), i, grad.Data()[i])
+				}
+			case math.Abs(v-x.Data()[i]/0.7) > 1e-12 || math.Abs(grad.Data()[i]-1/0.7) > 1e-12:
+				t.Fatalf("%s: kept unit %d is not scaled by 1/(1-rate)", tt.layer.Name(), i)
+			}
+			if tt.channels && (v == 0) != (out.Data()[i%8+(i/160)*160] == 0) {
+				t.Fatalf("%s: unit %d is not dropped with the rest of its channel", tt.layer.Name(), i)
+			}
+		}
+		if rate := float64(dropped) / float64(x.Size()); math.Abs(rate-0.3) > 0.05 {
+			t.Errorf("%s: dropped %v of the units, want about 0.3", tt.layer.Name(), rate)
+		}
+
+		tt.layer.SetSeed(5)
+		again, _ := tt.layer.Forward(x, true)
+		for i, v := range again.Data() {
+			if v != out.Data()[i] {
+				t.Fatalf("%s: the same seed gave a different mask", tt.layer.Name())
+			}
+		}
+		inference, err := tt.layer.Forward(x, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range inference.Data() {
+			if v != x.Data()[i] {
+				t.Fatalf("%s: inference changed unit %d", tt.layer.Name(), i)
+			}
+		}
+	}
+
+	alpha := AlphaDropout(0.2)
+	alpha.SetSeed(3)
+	out, err := alpha.Forward(x, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum, sq float64
+	for _, v := range out.Data() {
+		sum += v
+		sq += v * v
+	}
+	n := float64(out.Size())
+	if mean, variance := sum/n, sq/n-(sum/n)*(sum/n); math.Abs(mean) > 0.05 || math.Abs(variance-1) > 0.1 {
+		t.Errorf("alpha dropout changed the mean to %v and the variance to %v", mean, variance)
+	}
+	if _, err := Dropout(1).Forward(x, true); err == nil {
+		t.Error("expected an error for a rate of 1")
+	}
+}
+
+func softmaxOver(axis int, l Layer) Layer {
+	switch s := l.(type) {
+	case *SoftmaxLayer:
+		s.Axis = axis
+	case *LogSoftmaxLayer:
+		s.Axis = axis
+	}
+	return l
+}
+
+func TestSoftmax(t *testing.T) {
+	x := mustTensor(t, [][]float64{{1000, 1001, 999}, {-1000, 0, -5}})
+	probs, err := Softmax().Forward(x, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := LogSoftmax().Forward(x, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := 0; r < 2; r++ {
+		var sum float64
+		for c := 0; c < 3; c++ {
+			p, l := probs.Data()[r*3+c], logs.Data()[r*3+c]
+			if p < 0 || math.IsNaN(p) || math.IsInf(l, 0) || math.Abs(math.Exp(l)-p) > 1e-12 {
+				t.Fatalf("row %d: probability %v and log-probability %v", r, p, l)
+			}
+			sum += p
+		}
+		if math.Abs(sum-1) > 1e-12 {
+			t.Errorf("row %d sums to %v", r, sum)
+		}
+	}
+	if want := -1000 - math.Log(math.Exp(-1000)+1+math.Exp(-5)); math.Abs(logs.Data()[3]-want) > 1e-9 {
+		t.Errorf("log-softmax of -1000 = %v, want %v", logs.Data()[3], want)
+	}
+
+	y := mustTensor(t, [][]float64{{0, 0, 1}, {1, 0, 0}})
+	fromLogits := CategoricalCrossEntropy{FromLogits: true}
+	loss, err := fromLogits.Compute(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := -(logs.Data()[2] + logs.Data()[3]) / 2; math.Abs(loss-want) > 1e-9 {
+		t.Errorf("cross entropy from logits = %v, want %v", loss, want)
+	}
+	grad, err := fromLogits.Gradient(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range grad.Data() {
+		if want := (probs.Data()[i] - y.Data()[i]) / 2; math.Abs(g-want) > 1e-12 {
+			t.Errorf("gradient %d = %v, want (p - y)/batch = %v", i, g, want)
+		}
+	}
+}
+
+func TestShapeLayers(t *testing.T) {
+	x := tensor.Zeros([]int{2, 3, 4})
+	for i := range x.Data() {
+		x.Data()[i] = float64(i)
+	}
+	for _, tt := range []struct {
+		layer Layer
+		shape []int
+		at    []int //index of the output holding x[1, 2, 3]
+	}{
+		{Flatten(), []int{2, 12}, []int{1, 11}},
+		{Reshape(6, -1), []int{2, 6, 2}, []int{1, 5, 1}},
+		{Permute(2, 1), []int{2, 4, 3}, []int{1, 3, 2}},
+	} {
+		out, err := tt.layer.Forward(x, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalShapes(out.Shape(), tt.shape) || out.At(tt.at...) != x.At(1, 2, 3) {
+			t.Errorf("%s: got shape %v and %v at %v, want shape %v and %v", tt.layer.Name(), out.Shape(), out.At(tt.at...), tt.at, tt.shape, x.At(1, 2, 3))
+		}
+		grad, err := tt.layer.Backward(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalShapes(grad.Shape(), x.Shape()) || grad.At(1, 2, 3) != x.At(1, 2, 3) {
+			t.Errorf("%s: backward does not reverse the reshaping", tt.layer.Name())
+		}
+	}
+	for _, l := range []Layer{Reshape(5, -1), Reshape(-1, -1), Permute(1, 1), Permute(1), RepeatVector(2)} {
+		if _, err := l.Forward(x, false); err == nil {
+			t.Errorf("%s: expected an error for inputs of shape %v", l.Name(), x.Shape())
+		}
+	}
+	repeated, err := RepeatVector(3).Forward(mustTensor(t, [][]float64{{1, 2}, {3, 4}}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalShapes(repeated.Shape(), []int{2, 3, 2}) || repeated.At(1, 2, 0) != 3 || repeated.At(0, 1, 1) != 2 {
+		t.Errorf("RepeatVector gave %v", repeated)
+	}
+}
+
+//scaleLayer is the custom layer of the BaseLayer documentation: it multiplies its inputs by a weight per element.
+type scaleLayer struct {
+	BaseLayer
+	scale  *Parameter
+	inputs *tensor.Tensor
+}
+
+func (s *scaleLayer) Build(inputShape []int) ([]int, error) {
+	if s.scale == nil {
+		s.scale = s.AddWeight("scale", OtherWeight, inputShape, OnesInitializer, true)
+	}
+	return inputShape, nil
+}
+
+func (s *scaleLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	if _, err := s.Build(inputs.Shape()[1:]); err != nil {
+		return nil, err
+	}
+	s.inputs = inputs
+	return inputs.Multiply(s.scale.Value)
+}
+
+func (s *scaleLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	grad, err := gradOutput.Multiply(s.inputs)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := grad.SumAxis(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.scale.Accumulate(sum); err != nil {
+		return nil, err
+	}
+	return gradOutput.Multiply(s.scale.Value)
+}
+
+func TestCustomLayer(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	custom := &scaleLayer{BaseLayer: NewBaseLayer("scale")}
+	checkGradients(t, custom, randomTensor(rng, []int{3, 4}), 1e-4)
+	if got := custom.TrainableParameters(); got != 4 {
+		t.Errorf("trainable parameters = %d, want 4", got)
+	}
+
+	newModel := func() *Model {
+		scale := &scaleLayer{BaseLayer: NewBaseLayer("scale")}
+		scale.SetName("custom")
+		model := Sequential([]Layer{Input([]int{2}), scale, Dense(1, Linear)}, "custom")
+		model.Compile(SGD(0.1), MeanSquaredError{}, nil)
+		return model
+	}
+	x := mustTensor(t, [][]float64{{1, 0}, {0, 1}, {1, 1}, {0, 0}})
+	y := mustTensor(t, [][]float64{{2}, {-1}, {1}, {0}})
+	model := newModel()
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 5, BatchSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+	c := model.Checkpoint(true)
+	if len(c.Parameters) != 3 || c.Parameters[0].Name != "custom/scale" {
+		t.Fatalf("checkpoint does not start with the custom layer's weight: %+v", c.Parameters)
+	}
+	restored := newModel()
+	if err := restored.RestoreCheckpoint(c); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range restored.Parameters() {
+		for j, v := range p.Value.Data() {
+			if v != c.Parameters[i].Data[j] {
+				t.Fatalf("%s was not restored", p.Name)
+			}
+		}
+	}
+}
+
+func TestTransformerEncoderSetName(t *testing.T) {
+	e := TransformerEncoder(1, 2, 3)
+	e.SetName("encoder")
+	if _, err := e.Build([]int{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range e.Parameters() {
+		if !strings.HasPrefix(p.Name, "encoder/") {
+			t.Errorf("parameter %s is not named after the block", p.Name)
+		}
+	}
+}
+
+func TestTransformerEncoderNamesItsSublayers(t *testing.T) {
+	counts := func() [3]int {
+		layerNamesMu.Lock()
+		defer layerNamesMu.Unlock()
+		return [3]int{layerNames["dense"], layerNames["layer_normalization"], layerNames["multi_head_attention"]}
+	}
+	before := counts()
+	e := TransformerEncoder(1, 2, 3)
+	if _, err := e.Build([]int{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if after := counts(); after != before {
+		t.Errorf("the block took names of other layers: counters went from %v to %v", before, after)
+	}
+	for _, l := range e.Layers() {
+		if !strings.HasPrefix(l.Name(), e.Name()+"/") {
+			t.Errorf("sublayer %s is not named after %s", l.Name(), e.Name())
+		}
+	}
+}
+
+func TestGemmPropagatesNaN(t *testing.T) {
+	//a zero in a does not hide a NaN or an infinity in b
+	a, b := []float64{0, 1}, []float64{math.NaN(), math.Inf(1), 1, 2}
+	c := make([]float64, 2)
+	gemm(1, 2, 2, a, 2, b, 2, c, 2)
+	if !math.IsNaN(c[0]) || !math.IsNaN(c[1]) {
+		t.Errorf("gemm: got %v, want [NaN NaN]", c)
+	}
+	c = make([]float64, 4)
+	gemmTA(1, 2, 2, a, 2, b[:2], 2, c, 2)
+	if !math.IsNaN(c[0]) || !math.IsNaN(c[1]) {
+		t.Errorf("gemmTA: got %v, want NaN in the first row", c)
+	}
+}
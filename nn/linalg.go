@@ -0,0 +1,47 @@
+package neuralnetwork
+
+//The layers that work on raw buffers, like the recurrent and attention layers, use these helpers for their
+//matrix products.
+
+//gemm adds a x b to c, where a is [n, k] and b is [k, m]. The ld arguments are the row lengths of the underlying
+//buffers, which lets the matrices be column slices of wider ones.
+func gemm(n, k, m int, a []float64, lda int, b []float64, ldb int, c []float64, ldc int) {
+	for i := 0; i < n; i++ {
+		for p := 0; p < k; p++ {
+			v := a[i*lda+p]
+			row, out := b[p*ldb:p*ldb+m], c[i*ldc:i*ldc+m]
+			for j := range out {
+				out[j] += v * row[j]
+			}
+		}
+	}
+}
+
+//gemmTA adds transpose(a) x b to c, where a is [n, k] and b is [n, m].
+func gemmTA(n, k, m int, a []float64, lda int, b []float64, ldb int, c []float64, ldc int) {
+	for i := 0; i < n; i++ {
+		row := b[i*ldb : i*ldb+m]
+		for p := 0; p < k; p++ {
+			v := a[i*lda+p]
+			out := c[p*ldc : p*ldc+m]
+			for j := range out {
+				out[j] += v * row[j]
+			}
+		}
+	}
+}
+
+//gemmTB adds a x transpose(b) to c, where a is [n, m] and b is [k, m].
+func gemmTB(n, k, m int, a []float64, lda int, b []float64, ldb int, c []float64, ldc int) {
+	for i := 0; i < n; i++ {
+		row := a[i*lda : i*lda+m]
+		for p := 0; p < k; p++ {
+			col := b[p*ldb : p*ldb+m]
+			var sum float64
+			for j := range row {
+				sum += row[j] * col[j]
+			}
+			c[i*ldc+p] += sum
+		}
+	}
+}
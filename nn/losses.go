@@ -0,0 +1,168 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//Loss interface is passed to the model compilation. Compute returns the loss averaged over the batch and
+//Gradient returns its derivative with respect to the predictions, which starts the backward pass.
+type Loss interface {
+	Compute(prediction, truth *tensor.Tensor) (float64, error)
+	Gradient(prediction, truth *tensor.Tensor) (*tensor.Tensor, error)
+	Name() string
+}
+
+//logEpsilon keeps the logarithms in the cross entropy losses finite.
+const logEpsilon = 1e-12
+
+func checkLossShapes(prediction, truth *tensor.Tensor) error {
+	p, t := prediction.Shape(), truth.Shape()
+	if prediction.Size() != truth.Size() || len(p) == 0 || len(t) == 0 || p[0] != t[0] {
+		return fmt.Errorf("prediction shape %v does not match target shape %v", p, t)
+	}
+	return nil
+}
+
+func batchSize(t *tensor.Tensor) float64 {
+	if t.Rank() == 0 {
+		return 1
+	}
+	return float64(t.Shape()[0])
+}
+
+//MeanSquaredError loss. Squared errors are summed over the features and averaged over the batch.
+type MeanSquaredError struct{}
+
+//Compute returns the mean squared error.
+func (MeanSquaredError) Compute(prediction, truth *tensor.Tensor) (float64, error) {
+	if err := checkLossShapes(prediction, truth); err != nil {
+		return 0, err
+	}
+	return Mse(prediction.Data(), truth.Data()) / batchSize(prediction), nil
+}
+
+//Gradient returns 2(prediction-truth)/batch.
+func (MeanSquaredError) Gradient(prediction, truth *tensor.Tensor) (*tensor.Tensor, error) {
+	if err := checkLossShapes(prediction, truth); err != nil {
+		return nil, err
+	}
+	p, t, n := prediction.Data(), truth.Data(), batchSize(prediction)
+	grad := tensor.Zeros(prediction.Shape())
+	g := grad.Data()
+	for i := range g {
+		g[i] = 2 * (p[i] - t[i]) / n
+	}
+	return grad, nil
+}
+
+//Name of the loss.
+func (MeanSquaredError) Name() string {
+	return "mse"
+}
+
+//BinaryCrossEntropy loss for sigmoid outputs with targets in [0, 1].
+type BinaryCrossEntropy struct{}
+
+//Compute returns the binary cross entropy.
+func (BinaryCrossEntropy) Compute(prediction, truth *tensor.Tensor) (float64, error) {
+	if err := checkLossShapes(prediction, truth); err != nil {
+		return 0, err
+	}
+	p, t := prediction.Data(), truth.Data()
+	var loss float64
+	for i := range p {
+		loss -= t[i]*math.Log(p[i]+logEpsilon) + (1-t[i])*math.Log(1-p[i]+logEpsilon)
+	}
+	return loss / batchSize(prediction), nil
+}
+
+//Gradient returns the derivative of the binary cross entropy.
+func (BinaryCrossEntropy) Gradient(prediction, truth *tensor.Tensor) (*tensor.Tensor, error) {
+	if err := checkLossShapes(prediction, truth); err != nil {
+		return nil, err
+	}
+	p, t, n := prediction.Data(), truth.Data(), batchSize(prediction)
+	grad := tensor.Zeros(prediction.Shape())
+	g := grad.Data()
+	for i := range g {
+		g[i] = (p[i] - t[i]) / ((p[i]*(1-p[i]) + logEpsilon) * n)
+	}
+	return grad, nil
+}
+
+//Name of the loss.
+func (BinaryCrossEntropy) Name() string {
+	return "binary_crossentropy"
+}
+
+//CategoricalCrossEntropy loss for probability outputs and one-hot targets over the last axis. With FromLogits set,
+//the outputs are unnormalized scores and the loss applies the softmax itself.
+type CategoricalCrossEntropy struct {
+	FromLogits bool
+}
+
+//logProbabilities returns the logarithms of the predicted probabilities, computed from the logits with a stable
+//log-softmax when FromLogits is set.
+func (c CategoricalCrossEntropy) logProbabilities(prediction *tensor.Tensor) []float64 {
+	p := prediction.Data()
+	if c.FromLogits {
+		classes := prediction.Shape()[prediction.Rank()-1]
+		return softmaxAxis(p, len(p)/classes, classes, 1, true)
+	}
+	logs := make([]float64, len(p))
+	for i, v := range p {
+		logs[i] = math.Log(v + logEpsilon)
+	}
+	return logs
+}
+
+//Compute returns the categorical cross entropy.
+func (c CategoricalCrossEntropy) Compute(prediction, truth *tensor.Tensor) (float64, error) {
+	if err := checkLossShapes(prediction, truth); err != nil {
+		return 0, err
+	}
+	logs, t := c.logProbabilities(prediction), truth.Data()
+	var loss float64
+	for i := range logs {
+		loss -= t[i] * logs[i]
+	}
+	return loss / batchSize(prediction), nil
+}
+
+//Gradient returns -truth/prediction averaged over the batch, or (softmax(prediction) - truth)/batch from logits.
+func (c CategoricalCrossEntropy) Gradient(prediction, truth *tensor.Tensor) (*tensor.Tensor, error) {
+	if err := checkLossShapes(prediction, truth); err != nil {
+		return nil, err
+	}
+	if c.FromLogits {
+		classes := prediction.Shape()[prediction.Rank()-1]
+		p := softmaxAxis(prediction.Data(), prediction.Size()/classes, classes, 1, false)
+		return softmaxGradient(p, truth, prediction.Shape())
+	}
+	p, t, n := prediction.Data(), truth.Data(), batchSize(prediction)
+	grad := tensor.Zeros(prediction.Shape())
+	g := grad.Data()
+	for i := range g {
+		g[i] = -t[i] / ((p[i] + logEpsilon) * n)
+	}
+	return grad, nil
+}
+
+//softmaxGradient returns (p - truth)/batch, the gradient of the cross entropy of softmax probabilities p with respect
+//to the logits.
+func softmaxGradient(p []float64, truth *tensor.Tensor, shape []int) (*tensor.Tensor, error) {
+	t, n := truth.Data(), float64(shape[0])
+	g := make([]float64, len(p))
+	for i := range g {
+		g[i] = (p[i] - t[i]) / n
+	}
+	return tensor.FromSlice(g, shape)
+}
+
+//Name of the loss.
+func (CategoricalCrossEntropy) Name() string {
+	return "categorical_crossentropy"
+}
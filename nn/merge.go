@@ -0,0 +1,238 @@
+package neuralnetwork
+
+import (
+	"fmt"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//merge holds what the merge layers share. They combine the outputs of several nodes of a functional model and are
+//applied with Apply; called like a single-input layer, they fail.
+type merge struct {
+	BaseLayer
+}
+
+//Build fails: merge layers take several inputs.
+func (m *merge) Build(inputShape []int) ([]int, error) {
+	return nil, fmt.Errorf("%s: merge layers take several inputs, use Apply", m.name)
+}
+
+//Forward fails: merge layers take several inputs.
+func (m *merge) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	return nil, fmt.Errorf("%s: merge layers take several inputs, use Apply", m.name)
+}
+
+//Backward fails: merge layers take several inputs.
+func (m *merge) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	return nil, fmt.Errorf("%s: merge layers take several inputs, use Apply", m.name)
+}
+
+//combination is how an elementwise merge combines its inputs.
+type combination int
+
+const (
+	combineSum combination = iota
+	combineProduct
+	combineMean
+)
+
+//elementwise merges inputs of the same shape element by element.
+type elementwise struct {
+	merge
+	combine combination
+	inputs  [][]float64
+	shape   []int
+}
+
+//BuildInputs checks that there are at least two inputs of the same shape, which is the shape of the outputs.
+func (e *elementwise) BuildInputs(inputShapes [][]int) ([]int, error) {
+	if len(inputShapes) < 2 {
+		return nil, fmt.Errorf("%s: expected at least two inputs, got %d", e.name, len(inputShapes))
+	}
+	for _, s := range inputShapes[1:] {
+		if !equalShapes(s, inputShapes[0]) {
+			return nil, fmt.Errorf("%s: inputs of shapes %v and %v cannot be merged", e.name, inputShapes[0], s)
+		}
+	}
+	return append([]int(nil), inputShapes[0]...), nil
+}
+
+//ForwardInputs combines the inputs.
+func (e *elementwise) ForwardInputs(inputs []*tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shapes := make([][]int, len(inputs))
+	for i, in := range inputs {
+		shapes[i] = in.Shape()
+	}
+	shape, err := e.BuildInputs(shapes)
+	if err != nil {
+		return nil, err
+	}
+	e.inputs, e.shape = make([][]float64, len(inputs)), shape
+	out := make([]float64, inputs[0].Size())
+	for i, in := range inputs {
+		e.inputs[i] = in.Data()
+		for j, v := range e.inputs[i] {
+			switch {
+			case i == 0:
+				out[j] = v
+			case e.combine == combineProduct:
+				out[j] *= v
+			default:
+				out[j] += v
+			}
+		}
+	}
+	if e.combine == combineMean {
+		for j := range out {
+			out[j] /= float64(len(inputs))
+		}
+	}
+	return tensor.FromSlice(out, shape)
+}
+
+//BackwardInputs returns the gradient with respect to every input.
+func (e *elementwise) BackwardInputs(gradOutput *tensor.Tensor) ([]*tensor.Tensor, error) {
+	if e.inputs == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", e.name)
+	}
+	g := gradOutput.Data()
+	if len(g) != len(e.inputs[0]) {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", e.name, gradOutput.Shape())
+	}
+	grads := make([]*tensor.Tensor, len(e.inputs))
+	for i := range e.inputs {
+		dx := make([]float64, len(g))
+		for j, v := range g {
+			switch e.combine {
+			case combineSum:
+				dx[j] = v
+			case combineMean:
+				dx[j] = v / float64(len(e.inputs))
+			case combineProduct:
+				dx[j] = v
+				for k, other := range e.inputs {
+					if k != i {
+						dx[j] *= other[j]
+					}
+				}
+			}
+		}
+		var err error
+		if grads[i], err = tensor.FromSlice(dx, e.shape); err != nil {
+			return nil, err
+		}
+	}
+	return grads, nil
+}
+
+//AddLayer sums its inputs, for instance to close a residual connection.
+type AddLayer struct {
+	elementwise
+}
+
+//Add returns a layer summing inputs of the same shape.
+func Add() *AddLayer {
+	return &AddLayer{elementwise{merge: merge{NewBaseLayer("add")}, combine: combineSum}}
+}
+
+//MultiplyLayer multiplies its inputs element by element, for instance to gate one branch with another.
+type MultiplyLayer struct {
+	elementwise
+}
+
+//Multiply returns a layer multiplying inputs of the same shape.
+func Multiply() *MultiplyLayer {
+	return &MultiplyLayer{elementwise{merge: merge{NewBaseLayer("multiply")}, combine: combineProduct}}
+}
+
+//AverageLayer averages its inputs element by element.
+type AverageLayer struct {
+	elementwise
+}
+
+//Average returns a layer averaging inputs of the same shape.
+func Average() *AverageLayer {
+	return &AverageLayer{elementwise{merge: merge{NewBaseLayer("average")}, combine: combineMean}}
+}
+
+//ConcatenateLayer joins its inputs along an axis.
+type ConcatenateLayer struct {
+	merge
+	sizes []int
+	axis  int
+	//Axis is the axis to join along, batch axis included: -1, the default, for the last one. The inputs must have
+	//the same size along every other axis.
+	Axis int
+}
+
+//Concatenate returns a layer joining its inputs along their last axis.
+func Concatenate() *ConcatenateLayer {
+	return &ConcatenateLayer{merge: merge{NewBaseLayer("concatenate")}, Axis: -1}
+}
+
+//BuildInputs checks the shapes of the inputs and returns the shape of their concatenation.
+func (c *ConcatenateLayer) BuildInputs(inputShapes [][]int) ([]int, error) {
+	if len(inputShapes) < 2 {
+		return nil, fmt.Errorf("%s: expected at least two inputs, got %d", c.name, len(inputShapes))
+	}
+	rank := len(inputShapes[0]) + 1
+	axis := c.Axis
+	if axis < 0 {
+		axis += rank
+	}
+	if axis <= 0 || axis >= rank {
+		return nil, fmt.Errorf("%s: axis %d is not a feature axis of inputs of rank %d", c.name, c.Axis, rank)
+	}
+	out := append([]int(nil), inputShapes[0]...)
+	for _, s := range inputShapes[1:] {
+		if len(s) != len(out) {
+			return nil, fmt.Errorf("%s: inputs of shapes %v and %v cannot be concatenated", c.name, inputShapes[0], s)
+		}
+		for i := range s {
+			if i != axis-1 && s[i] != out[i] {
+				return nil, fmt.Errorf("%s: inputs of shapes %v and %v cannot be concatenated along axis %d", c.name, inputShapes[0], s, c.Axis)
+			}
+		}
+		out[axis-1] += s[axis-1]
+	}
+	return out, nil
+}
+
+//ForwardInputs concatenates the inputs.
+func (c *ConcatenateLayer) ForwardInputs(inputs []*tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shapes := make([][]int, len(inputs))
+	for i, in := range inputs {
+		if shapes[i], _ = sampleShape(in); shapes[i] == nil || in.Shape()[0] != inputs[0].Shape()[0] {
+			return nil, fmt.Errorf("%s: inputs must be batches of the same number of samples", c.name)
+		}
+	}
+	if _, err := c.BuildInputs(shapes); err != nil {
+		return nil, err
+	}
+	c.axis = c.Axis
+	if c.axis < 0 {
+		c.axis += inputs[0].Rank()
+	}
+	c.sizes = make([]int, len(inputs))
+	for i, in := range inputs {
+		c.sizes[i] = in.Shape()[c.axis]
+	}
+	return tensor.Concat(inputs, c.axis)
+}
+
+//BackwardInputs splits the gradient between the inputs.
+func (c *ConcatenateLayer) BackwardInputs(gradOutput *tensor.Tensor) ([]*tensor.Tensor, error) {
+	if c.sizes == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", c.name)
+	}
+	grads := make([]*tensor.Tensor, len(c.sizes))
+	start := 0
+	for i, size := range c.sizes {
+		g, err := gradOutput.Gather(start, start+size, c.axis)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.name, err)
+		}
+		grads[i], start = g.Contiguous(), start+size
+	}
+	return grads, nil
+}
@@ -121,7 +121,8 @@ func sum(values []float64) float64 {
 	return total
 }
 
-//RidgeRegression returns the RidgeRegression or the l2 regularization to the loss function.
+//RidgeRegression returns the squared error of pred plus lambda times the squares of actual. It does not see the
+//weights of a model: to penalize them, set an L2 regularizer on the layers.
 func RidgeRegression(actual, pred []float64, lambda float64) float64 {
 	var loss float64
 	var l2 float64
@@ -133,7 +134,8 @@ func RidgeRegression(actual, pred []float64, lambda float64) float64 {
 	return loss + l2
 }
 
-//LassoRegression returns the LassoRegression or the l1 regularization to the loss function.
+//LassoRegression returns the squared error of pred plus lambda times the absolute values of actual. It does not see
+//the weights of a model: to penalize them, set an L1 regularizer on the layers.
 func LassoRegression(actual, pred []float64, lambda float64) float64 {
 	var loss float64
 	var l1 float64
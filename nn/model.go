@@ -3,22 +3,31 @@ package neuralnetwork
 
 import (
 	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/timothy102/neuralnetwork/tensor"
 )
 
 //Model implements the model architecure.
 type Model struct {
 	layers                 []Layer
 	name                   string
+	net                    *graph
+	netErr                 error
+	functional             bool
 	optimizer              Optimizer
-	loss                   func([]float64, []float64) float64
+	loss                   Loss
+	losses                 []Loss //by output when compiled with CompileOutputs, nil otherwise
+	lossWeights            []float64
 	lossValues             []float64
 	trainingDuration       time.Duration
 	modelMetrics           []Metrics
-	trainDataX, trainDataY []float64
+	trainDataX, trainDataY []*tensor.Tensor
 	callbacks              []Callback
 	training               bool
-	learningRate           float64
 	trainingLog            TrainingLog
 }
 
@@ -29,8 +38,12 @@ type Metrics interface {
 }
 
 //Optimizer interface requires an ApplyGradients function. Pass it to the model compilation.
+//ApplyGradients receives every trainable parameter of the model with the gradient the backward pass accumulated for it.
+//The learning rate is exposed so that schedulers and callbacks can change it during training.
 type Optimizer interface {
-	ApplyGradients()
+	ApplyGradients(params []*Parameter)
+	LearningRate() float64
+	SetLearningRate(lr float64)
 }
 
 //TrainingLog returns model's log
@@ -43,12 +56,86 @@ func Sequential(layers []Layer, name string) *Model {
 	return &Model{layers: layers, name: name}
 }
 
-//Add method adds a layer to the end of the model architecture
+//Add method adds a layer to the end of the model architecture. In a functional model the layer takes the model's
+//only output; with several outputs the model reports an error when it runs.
 func (m *Model) Add(layer Layer) *Model {
-	m.layers[len(m.layers)] = layer
+	if !m.functional {
+		m.layers = append(m.layers, layer)
+		m.net = nil
+		return m
+	}
+	if len(m.net.outputs) != 1 {
+		m.netErr = fmt.Errorf("model %s: cannot add %s to a model with %d outputs", m.name, layer.Name(), len(m.net.outputs))
+		return m
+	}
+	net, err := newGraph(m.net.inputs, []*Node{Apply(layer, m.net.outputs[0])})
+	if err != nil {
+		m.netErr = fmt.Errorf("model %s: %v", m.name, err)
+		return m
+	}
+	m.net, m.layers = net, net.layers
 	return m
 }
 
+//network returns the graph of the model, a chain of its layers for a sequential model.
+func (m *Model) network() (*graph, error) {
+	if m.net == nil {
+		var err error
+		if m.net, err = chain(m.layers); err != nil {
+			m.netErr = fmt.Errorf("model %s: %v", m.name, err)
+		}
+	}
+	return m.net, m.netErr
+}
+
+//InputNames returns the names of the model inputs, which the Data given to FitData, PredictData and EvaluateData
+//uses: the names of the Input layers, or "input" for a sequential model without one.
+func (m *Model) InputNames() []string {
+	if !m.functional {
+		if len(m.layers) > 0 {
+			if in, ok := m.layers[0].(*InputLayer); ok {
+				return []string{in.Name()}
+			}
+		}
+		return []string{"input"}
+	}
+	names := make([]string, len(m.net.inputs))
+	for i, in := range m.net.inputs {
+		names[i] = in.layer.Name()
+	}
+	return names
+}
+
+//OutputNames returns the names of the model outputs: the names of the layers producing them, followed by ":1", ":2"...
+//for the extra outputs of a MultiOutputLayer.
+func (m *Model) OutputNames() []string {
+	if !m.functional {
+		if len(m.layers) == 0 {
+			return []string{"output"}
+		}
+		return []string{m.layers[len(m.layers)-1].Name()}
+	}
+	names := make([]string, len(m.net.outputs))
+	for i, out := range m.net.outputs {
+		names[i] = out.name()
+	}
+	return names
+}
+
+//ordered returns the tensors of d in the order of names, which must be exactly its keys.
+func ordered(d Data, names []string) ([]*tensor.Tensor, error) {
+	ts := make([]*tensor.Tensor, len(names))
+	for i, name := range names {
+		if ts[i] = d[name]; ts[i] == nil {
+			return nil, fmt.Errorf("no data for %s", name)
+		}
+	}
+	if len(d) != len(names) {
+		return nil, fmt.Errorf("expected data for %v, got %d tensors", names, len(d))
+	}
+	return ts, nil
+}
+
 //GetLayerByIndex returns the ith layer.
 func (m *Model) GetLayerByIndex(index int) Layer {
 	return m.layers[index]
@@ -69,56 +156,761 @@ func (m *Model) GetLayerByName(name string) Layer {
 	return m.layers[0]
 }
 
-//Compile compiles the model given the optimizer, loss and metrics
-func (m *Model) Compile(optimizer Optimizer, loss func([]float64, []float64) float64, ms []Metrics) {
+//Compile compiles the model given the optimizer, loss and metrics. Every output of the model is trained with the loss.
+func (m *Model) Compile(optimizer Optimizer, loss Loss, ms []Metrics) {
 	m.optimizer = optimizer
 	m.loss = loss
+	m.losses, m.lossWeights = nil, nil
 	m.modelMetrics = ms
 }
 
-//Predict does the feed forward magic when fed the inputs.
-func (m *Model) Predict(values []float64) []float64 {
-	var outputs []float64
-	for i := range m.layers {
-		outputs = m.layers[i].Call()
-		m.layers[i+1].Call()
-		if i == len(m.layers)-1 {
-			return outputs
+//CompileOutputs compiles a model with several outputs, each trained with its own loss. The loss minimized is the sum
+//of the losses of the outputs multiplied by their weights, 1 for the outputs missing from lossWeights. Outputs
+//missing from losses are not trained. The metrics are measured on every output.
+func (m *Model) CompileOutputs(optimizer Optimizer, losses map[string]Loss, lossWeights map[string]float64, ms []Metrics) error {
+	names := m.OutputNames()
+	index := make(map[string]int, len(names))
+	for i, name := range names {
+		index[name] = i
+	}
+	for name := range losses {
+		if _, ok := index[name]; !ok {
+			return fmt.Errorf("model %s has no output %s, its outputs are %v", m.name, name, names)
 		}
 	}
-	return outputs
+	for name := range lossWeights {
+		if _, ok := index[name]; !ok {
+			return fmt.Errorf("model %s has no output %s, its outputs are %v", m.name, name, names)
+		}
+	}
+	if len(losses) == 0 {
+		return fmt.Errorf("model %s: expected a loss for at least one output", m.name)
+	}
+	m.optimizer, m.loss, m.modelMetrics = optimizer, nil, ms
+	m.losses, m.lossWeights = make([]Loss, len(names)), make([]float64, len(names))
+	for i, name := range names {
+		m.losses[i], m.lossWeights[i] = losses[name], 1
+		if w, ok := lossWeights[name]; ok {
+			m.lossWeights[i] = w
+		}
+	}
+	return nil
 }
 
-//Train trains the model given trainX and  trainY data and the number of epochs. It keeps track of the defined metrics and prints it every epoch. It also prints the training duration.
-//It returns a map from strings to floats, where strings represent the metrics name and float the metrics value.
-func (m *Model) Train(trainX, trainY []float64, epochs int) map[string]float64 {
-	startTime := time.Now()
-	metricsValues := make(map[string]float64, len(m.modelMetrics))
-	for i := 1; i < epochs; i++ {
-		for j := 0; j < len(trainX); j++ {
-			lossValue := m.loss(m.Predict(trainX), trainY)
-			m.lossValues = append(m.lossValues, lossValue)
-			m.optimizer.ApplyGradients()
+//compiled reports whether the model has a loss.
+func (m *Model) compiled() bool {
+	return m.loss != nil || m.losses != nil
+}
+
+//outputLoss returns the loss of output i and its weight. The loss is nil for outputs that are not trained.
+func (m *Model) outputLoss(i int) (Loss, float64) {
+	if m.losses == nil {
+		return m.loss, 1
+	}
+	return m.losses[i], m.lossWeights[i]
+}
+
+//forward runs the inputs through a model with one input and one output. Layers such as dropout behave differently
+//while training.
+func (m *Model) forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	g, err := m.network()
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := g.run([]*tensor.Tensor{inputs}, training)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs) != 1 {
+		return nil, fmt.Errorf("model %s has %d outputs, use PredictData", m.name, len(outputs))
+	}
+	return outputs[0], nil
+}
+
+//defaultBatchSize is the number of samples per batch used when none is configured.
+const defaultBatchSize = 32
+
+//Predict does the feed forward magic when fed the inputs. The first axis of values indexes the samples, which are run
+//through the model in inference mode, so dropout is disabled, in batches of 32.
+func (m *Model) Predict(values *tensor.Tensor) (*tensor.Tensor, error) {
+	return m.PredictBatched(values, defaultBatchSize)
+}
+
+//PredictBatched is Predict with a custom number of samples per forward pass.
+func (m *Model) PredictBatched(values *tensor.Tensor, batchSize int) (*tensor.Tensor, error) {
+	if values.Rank() == 0 {
+		return nil, fmt.Errorf("expected a batch of samples, got a scalar")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+	outputs, err := m.predict([]*tensor.Tensor{values}, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(outputs) != 1 {
+		return nil, fmt.Errorf("model %s has %d outputs, use PredictData", m.name, len(outputs))
+	}
+	return outputs[0], nil
+}
+
+//PredictData runs the inputs, by input name, through the model in inference mode and returns the outputs by name.
+func (m *Model) PredictData(x Data) (Data, error) {
+	xs, err := ordered(x, m.InputNames())
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := m.predict(xs, defaultBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	pred := make(Data, len(outputs))
+	for i, name := range m.OutputNames() {
+		pred[name] = outputs[i]
+	}
+	return pred, nil
+}
+
+//predict runs the inputs through the model in inference mode in batches of batchSize samples.
+func (m *Model) predict(inputs []*tensor.Tensor, batchSize int) ([]*tensor.Tensor, error) {
+	n, err := numSamples(inputs)
+	if err != nil {
+		return nil, err
+	}
+	g, err := m.network()
+	if err != nil {
+		return nil, err
+	}
+	if n <= batchSize {
+		return g.run(inputs, false)
+	}
+	parts := make([][]*tensor.Tensor, len(g.outputs))
+	for start := 0; start < n; start += batchSize {
+		end := start + batchSize
+		if end > n {
+			end = n
+		}
+		batch, err := gatherSamples(inputs, start, end)
+		if err != nil {
+			return nil, err
+		}
+		outputs, err := g.run(batch, false)
+		if err != nil {
+			return nil, err
+		}
+		for i, out := range outputs {
+			parts[i] = append(parts[i], out.Clone())
+		}
+	}
+	outputs := make([]*tensor.Tensor, len(parts))
+	for i, p := range parts {
+		if outputs[i], err = tensor.Concat(p, 0); err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+//gatherSamples returns the samples start to end, excluded, of every tensor.
+func gatherSamples(ts []*tensor.Tensor, start, end int) ([]*tensor.Tensor, error) {
+	out := make([]*tensor.Tensor, len(ts))
+	for i, t := range ts {
+		var err error
+		if out[i], err = t.Gather(start, end, 0); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+//takeSamples returns the samples of every tensor at the given indices.
+func takeSamples(ts []*tensor.Tensor, indices []int) ([]*tensor.Tensor, error) {
+	out := make([]*tensor.Tensor, len(ts))
+	for i, t := range ts {
+		var err error
+		if out[i], err = t.Take(indices); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+//Evaluate returns the loss under "loss" and every compiled metric under its name, computed on x and y in inference mode.
+//As in training, the loss includes the penalties of the regularizers, which are also logged as "regularization_loss".
+func (m *Model) Evaluate(x, y *tensor.Tensor) (map[string]float64, error) {
+	if !m.compiled() {
+		return nil, fmt.Errorf("model %s must be compiled before evaluation", m.name)
+	}
+	return m.evaluate([]*tensor.Tensor{x}, []*tensor.Tensor{y}, defaultBatchSize)
+}
+
+//EvaluateData is Evaluate for the inputs and targets of a model with several inputs or outputs, given by name.
+//Next to the total loss, the logs hold the loss and the metrics of every output under "<output>_" names.
+func (m *Model) EvaluateData(x, y Data) (map[string]float64, error) {
+	if !m.compiled() {
+		return nil, fmt.Errorf("model %s must be compiled before evaluation", m.name)
+	}
+	xs, ys, err := m.orderedData(x, y)
+	if err != nil {
+		return nil, err
+	}
+	return m.evaluate(xs, ys, defaultBatchSize)
+}
+
+//orderedData returns the inputs and the targets in the order of the model inputs and outputs.
+func (m *Model) orderedData(x, y Data) ([]*tensor.Tensor, []*tensor.Tensor, error) {
+	xs, err := ordered(x, m.InputNames())
+	if err != nil {
+		return nil, nil, fmt.Errorf("inputs: %v", err)
+	}
+	ys, err := ordered(y, m.OutputNames())
+	if err != nil {
+		return nil, nil, fmt.Errorf("targets: %v", err)
+	}
+	return xs, ys, nil
+}
+
+//Build builds every layer for samples of inputShape, without the batch axis, and returns the output shape of the model.
+//Models whose first layer is an Input layer are built automatically when their summary is printed.
+func (m *Model) Build(inputShape []int) ([]int, error) {
+	if m.functional {
+		g, err := m.network()
+		if err != nil {
+			return nil, err
+		}
+		if len(g.inputs) != 1 || len(g.outputs) != 1 {
+			return nil, fmt.Errorf("model %s has %d inputs and %d outputs, it was built by Functional", m.name, len(g.inputs), len(g.outputs))
+		}
+		if !equalShapes(inputShape, g.inputs[0].shape) {
+			return nil, fmt.Errorf("model %s: expected samples of shape %v, got %v", m.name, g.inputs[0].shape, inputShape)
+		}
+		return g.outputs[0].Shape(), nil
+	}
+	shape := inputShape
+	for _, l := range m.layers {
+		var err error
+		if shape, err = l.Build(shape); err != nil {
+			return nil, err
+		}
+	}
+	return shape, nil
+}
+
+//autoBuild builds the model if its first layer is an Input layer. Other models are left as they are; functional models
+//are built by Functional.
+func (m *Model) autoBuild() error {
+	if m.functional {
+		_, err := m.network()
+		return err
+	}
+	if len(m.layers) == 0 {
+		return nil
+	}
+	if in, ok := m.layers[0].(*InputLayer); ok {
+		_, err := m.Build(in.Shape())
+		return err
+	}
+	return nil
+}
+
+//backward propagates the gradient of the loss with respect to the outputs of a model with one output down to its
+//inputs.
+func (m *Model) backward(grad *tensor.Tensor) error {
+	g, err := m.network()
+	if err != nil {
+		return err
+	}
+	if len(g.outputs) != 1 {
+		return fmt.Errorf("model %s has %d outputs", m.name, len(g.outputs))
+	}
+	return g.backward(map[*Node]*tensor.Tensor{g.outputs[0]: grad})
+}
+
+//SetTrainable freezes every layer of the model when trainable is false, or unfreezes them. Freezing a pretrained model
+//whose layers are reused in a new one keeps their weights while the new layers train.
+func (m *Model) SetTrainable(trainable bool) {
+	setTrainable(m.layers, trainable)
+}
+
+//Parameters returns the parameters of every layer in the model.
+func (m *Model) Parameters() []*Parameter {
+	var params []*Parameter
+	for _, l := range m.layers {
+		params = append(params, l.Parameters()...)
+	}
+	return params
+}
+
+//score adds the loss and the metrics of the predictions, multiplied by weight, to logs and returns the loss. With
+//several outputs, the loss and the metrics of each are also logged under "<output>_" names.
+func (m *Model) score(preds, ys []*tensor.Tensor, weight float64, logs map[string]float64) (float64, error) {
+	names := m.OutputNames()
+	var total float64
+	for i, pred := range preds {
+		prefix := ""
+		if len(preds) > 1 {
+			prefix = names[i] + "_"
+		}
+		if loss, lossWeight := m.outputLoss(i); loss != nil {
+			value, err := loss.Compute(pred, ys[i])
+			if err != nil {
+				return 0, fmt.Errorf("%s: %v", names[i], err)
+			}
+			total += lossWeight * value
+			if prefix != "" {
+				logs[prefix+"loss"] += value * weight
+			}
 		}
-		avg := meanValue(m.lossValues)
 		for _, met := range m.modelMetrics {
-			metricsValues[met.Name()] = met.Measure(m.Predict(trainX), trainY)
+			logs[prefix+met.Name()] += met.Measure(pred.Data(), ys[i].Data()) * weight
 		}
-		fmt.Printf("Epoch: %d		Loss:%.4f\n", i, avg)
 	}
-	endTime := time.Now()
-	m.trainingDuration = endTime.Sub(startTime)
-	fmt.Printf("Training duration: %s\n", m.trainingDuration.String())
-	return metricsValues
+	logs["loss"] += total * weight
+	return total, nil
 }
 
-//Summary prints the layer by layer summaary along with trainable parameters.
+//trainStep runs one forward and backward pass over x and y, lets the optimizer update the parameters and returns the
+//loss. The loss and the metrics of the predictions made before the update are added to logs, multiplied by weight.
+//The penalties of the regularizers are part of the loss and are also logged as "regularization_loss".
+func (m *Model) trainStep(x, y []*tensor.Tensor, weight float64, logs map[string]float64) (float64, error) {
+	g, err := m.network()
+	if err != nil {
+		return 0, err
+	}
+	preds, err := g.run(x, true)
+	if err != nil {
+		return 0, err
+	}
+	var params []*Parameter
+	for _, p := range m.Parameters() {
+		if p.Trainable {
+			p.ZeroGrad()
+		}
+		if p.updatable() {
+			params = append(params, p)
+		}
+	}
+	lossValue, err := m.score(preds, y, weight, logs)
+	if err != nil {
+		return 0, err
+	}
+	grads := map[*Node]*tensor.Tensor{}
+	for i, pred := range preds {
+		loss, lossWeight := m.outputLoss(i)
+		if loss == nil {
+			continue
+		}
+		out := g.outputs[i]
+		var grad *tensor.Tensor
+		if fusedSoftmax(g, out, loss, pred.Rank()) {
+			if err := checkLossShapes(pred, y[i]); err != nil {
+				return 0, err
+			}
+			out = out.inbound[0]
+			grad, err = softmaxGradient(pred.Data(), y[i], pred.Shape())
+		} else {
+			grad, err = loss.Gradient(pred, y[i])
+		}
+		if err != nil {
+			return 0, err
+		}
+		if lossWeight != 1 {
+			grad = grad.Scale(lossWeight)
+		}
+		if err := addGradient(grads, out, grad); err != nil {
+			return 0, err
+		}
+	}
+	penalty, regularized, err := g.regularize(grads)
+	if err != nil {
+		return 0, err
+	}
+	if regularized {
+		lossValue += penalty
+		logs["loss"] += penalty * weight
+		logs["regularization_loss"] += penalty * weight
+	}
+	if err := g.backward(grads); err != nil {
+		return 0, err
+	}
+	m.optimizer.ApplyGradients(params)
+	return lossValue, nil
+}
+
+//fusedSoftmax reports whether the output node out is a softmax over the last axis of outputs of the given rank that
+//nothing else uses, trained with the categorical cross entropy of its probabilities. The gradient of both together
+//with respect to the logits is then (p - y)/batch, which avoids dividing by probabilities close to zero.
+func fusedSoftmax(g *graph, out *Node, loss Loss, rank int) bool {
+	s, ok := out.layer.(*SoftmaxLayer)
+	if !ok || len(out.inbound) != 1 || g.consumers[out] != 0 || (s.Axis != -1 && s.Axis != rank-1) {
+		return false
+	}
+	c, ok := loss.(CategoricalCrossEntropy)
+	return ok && !c.FromLogits
+}
+
+//measure returns the value of met on the training data, for the first output.
+func (m *Model) measure(met Metrics) (float64, error) {
+	preds, err := m.predict(m.trainDataX, defaultBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return met.Measure(preds[0].Data(), m.trainDataY[0].Data()), nil
+}
+
+//FitConfig holds the settings of Model.Fit.
+type FitConfig struct {
+	Epochs    int
+	BatchSize int   //samples per gradient step, 32 if not set
+	Shuffle   bool  //reshuffle the samples before every epoch
+	Seed      int64 //seed of the shuffling, the same seed always gives the same batches
+	Verbose   bool  //print the loss and the metrics after every epoch
+
+	//ValidationX and ValidationY are evaluated after every epoch and reported with a "val_" prefix.
+	ValidationX, ValidationY *tensor.Tensor
+	//ValidationInputs and ValidationTargets are the validation data of FitData, by input and output name.
+	ValidationInputs, ValidationTargets Data
+	//ValidationSplit holds out this fraction of the samples, taken from the end of x and y before shuffling,
+	//as validation data. It is ignored when ValidationX is set.
+	ValidationSplit float64
+	Callbacks       []Callback
+}
+
+//History records the loss and every compiled metric after each epoch of Model.Fit.
+type History struct {
+	Epochs []int
+	Values map[string][]float64
+}
+
+func newHistory() *History {
+	return &History{Values: map[string][]float64{}}
+}
+
+func (h *History) add(epoch int, logs map[string]float64) {
+	h.Epochs = append(h.Epochs, epoch)
+	for k, v := range logs {
+		h.Values[k] = append(h.Values[k], v)
+	}
+}
+
+//Last returns the most recent value recorded under name, e.g. "loss".
+func (h *History) Last(name string) (float64, bool) {
+	v := h.Values[name]
+	if len(v) == 0 {
+		return 0, false
+	}
+	return v[len(v)-1], true
+}
+
+//numSamples checks that every tensor holds the same number of samples along its first axis and returns it.
+func numSamples(ts ...[]*tensor.Tensor) (int, error) {
+	n := -1
+	for _, group := range ts {
+		for _, t := range group {
+			if t == nil || t.Rank() == 0 {
+				return 0, fmt.Errorf("x and y must be tensors indexed by sample along their first axis")
+			}
+			if n == -1 {
+				n = t.Shape()[0]
+			} else if t.Shape()[0] != n {
+				return 0, fmt.Errorf("x and y hold %d and %d samples", n, t.Shape()[0])
+			}
+		}
+	}
+	if n == -1 {
+		return 0, fmt.Errorf("no data")
+	}
+	return n, nil
+}
+
+//evaluate returns the loss and every compiled metric on x and y, computed in inference mode in batches of batchSize
+//and averaged over the samples. As in trainStep, the penalties of the regularizers are part of the loss and are also
+//logged as "regularization_loss", so the validation loss compares with the training loss.
+func (m *Model) evaluate(x, y []*tensor.Tensor, batchSize int) (map[string]float64, error) {
+	n, err := numSamples(x, y)
+	if err != nil {
+		return nil, err
+	}
+	g, err := m.network()
+	if err != nil {
+		return nil, err
+	}
+	logs := make(map[string]float64, len(m.modelMetrics)+1)
+	for start := 0; start < n; start += batchSize {
+		end := start + batchSize
+		if end > n {
+			end = n
+		}
+		bx, err := gatherSamples(x, start, end)
+		if err != nil {
+			return nil, err
+		}
+		by, err := gatherSamples(y, start, end)
+		if err != nil {
+			return nil, err
+		}
+		preds, err := g.run(bx, false)
+		if err != nil {
+			return nil, err
+		}
+		weight := float64(end-start) / float64(n)
+		if _, err := m.score(preds, by, weight, logs); err != nil {
+			return nil, err
+		}
+		penalty, regularized, err := g.regularize(nil)
+		if err != nil {
+			return nil, err
+		}
+		if regularized {
+			logs["loss"] += penalty * weight
+			logs["regularization_loss"] += penalty * weight
+		}
+	}
+	return logs, nil
+}
+
+//splitValidation holds out the given fraction of the samples of x and y, taken from the end, as validation data.
+func splitValidation(x, y []*tensor.Tensor, n int, fraction float64) (trainX, trainY, valX, valY []*tensor.Tensor, err error) {
+	if fraction < 0 || fraction >= 1 {
+		return nil, nil, nil, nil, fmt.Errorf("validation split must be in [0, 1), got %v", fraction)
+	}
+	split := n - int(fraction*float64(n))
+	if split == n || split == 0 {
+		return nil, nil, nil, nil, fmt.Errorf("validation split %v of %d samples leaves no training or no validation samples", fraction, n)
+	}
+	if trainX, err = gatherSamples(x, 0, split); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if trainY, err = gatherSamples(y, 0, split); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if valX, err = gatherSamples(x, split, n); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if valY, err = gatherSamples(y, split, n); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return trainX, trainY, valX, valY, nil
+}
+
+//formatLogs renders the logs of an epoch sorted by name.
+func formatLogs(logs map[string]float64) string {
+	names := make([]string, 0, len(logs))
+	for k := range logs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, k := range names {
+		parts[i] = fmt.Sprintf("%s:%.4f", k, logs[k])
+	}
+	return strings.Join(parts, "		")
+}
+
+//Fit trains the model on x and y, whose first axis indexes the samples, in mini-batches of cfg.BatchSize.
+//It returns the History of the loss and the compiled metrics, averaged over the batches of each epoch,
+//together with the same values on the validation data under "val_" names. With a stateful layer, the numbers of
+//training and validation samples must be multiples of the batch size.
+func (m *Model) Fit(x, y *tensor.Tensor, cfg FitConfig) (*History, error) {
+	var valX, valY []*tensor.Tensor
+	if cfg.ValidationX != nil || cfg.ValidationY != nil {
+		valX, valY = []*tensor.Tensor{cfg.ValidationX}, []*tensor.Tensor{cfg.ValidationY}
+	}
+	return m.fit([]*tensor.Tensor{x}, []*tensor.Tensor{y}, valX, valY, cfg)
+}
+
+//FitData is Fit for a model with several inputs or outputs: x and y hold the inputs and the targets by name, and
+//the validation data is taken from cfg.ValidationInputs and cfg.ValidationTargets. Next to the total loss, the
+//history holds the loss and the metrics of every output under "<output>_" names.
+func (m *Model) FitData(x, y Data, cfg FitConfig) (*History, error) {
+	xs, ys, err := m.orderedData(x, y)
+	if err != nil {
+		return nil, err
+	}
+	var valX, valY []*tensor.Tensor
+	if cfg.ValidationInputs != nil || cfg.ValidationTargets != nil {
+		if valX, valY, err = m.orderedData(cfg.ValidationInputs, cfg.ValidationTargets); err != nil {
+			return nil, fmt.Errorf("validation data: %v", err)
+		}
+	}
+	return m.fit(xs, ys, valX, valY, cfg)
+}
+
+//fit trains the model on inputs x and targets y, ordered like the model inputs and outputs.
+func (m *Model) fit(x, y, valX, valY []*tensor.Tensor, cfg FitConfig) (*History, error) {
+	if m.optimizer == nil || !m.compiled() {
+		return nil, fmt.Errorf("model %s must be compiled before training", m.name)
+	}
+	n, err := numSamples(x, y)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	var valN int
+	if valX != nil {
+		if valN, err = numSamples(valX, valY); err != nil {
+			return nil, fmt.Errorf("validation data: %v", err)
+		}
+	} else if cfg.ValidationSplit != 0 {
+		if x, y, valX, valY, err = splitValidation(x, y, n, cfg.ValidationSplit); err != nil {
+			return nil, err
+		}
+		n, valN = x[0].Shape()[0], n-x[0].Shape()[0]
+	}
+	if hasStatefulLayer(m.layers) && (n%batchSize != 0 || valN%batchSize != 0) {
+		return nil, fmt.Errorf("model %s has a stateful layer: its %d training and %d validation samples must be multiples of the batch size %d", m.name, n, valN, batchSize)
+	}
+	m.trainDataX, m.trainDataY = x, y
+	m.callbacks = cfg.Callbacks
+	m.training = true
+	defer func() { m.training = false }()
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	history := newHistory()
+	startTime := time.Now()
+	for epoch := 0; epoch < cfg.Epochs && m.training; epoch++ {
+		if cfg.Shuffle {
+			rng.Shuffle(n, func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+		logs := make(map[string]float64, len(m.modelMetrics)+1)
+		for start := 0; start < n; start += batchSize {
+			end := start + batchSize
+			if end > n {
+				end = n
+			}
+			bx, err := takeSamples(x, order[start:end])
+			if err != nil {
+				return nil, err
+			}
+			by, err := takeSamples(y, order[start:end])
+			if err != nil {
+				return nil, err
+			}
+			if _, err := m.trainStep(bx, by, float64(end-start)/float64(n), logs); err != nil {
+				return nil, err
+			}
+		}
+		if valX != nil {
+			valLogs, err := m.evaluate(valX, valY, batchSize)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range valLogs {
+				logs["val_"+k] = v
+			}
+		}
+		m.lossValues = append(m.lossValues, logs["loss"])
+		history.add(epoch, logs)
+		line := fmt.Sprintf("Epoch: %d/%d		%s", epoch+1, cfg.Epochs, formatLogs(logs))
+		m.trainingLog.logs = append(m.trainingLog.logs, line)
+		if cfg.Verbose {
+			fmt.Println(line)
+		}
+		for _, c := range cfg.Callbacks {
+			if err := c.OnEpochEnd(m, epoch, logs); err != nil {
+				return history, err
+			}
+		}
+	}
+	m.trainingDuration = time.Since(startTime)
+	if cfg.Verbose {
+		fmt.Printf("Training duration: %s\n", m.trainingDuration.String())
+	}
+	return history, nil
+}
+
+//hasStatefulLayer reports whether one of the layers, or of the layers they track, is a stateful recurrent layer.
+func hasStatefulLayer(layers []Layer) bool {
+	for _, l := range layers {
+		if s, ok := l.(interface{ stateful() bool }); ok && s.stateful() {
+			return true
+		}
+		if t, ok := l.(interface{ Layers() []Layer }); ok && hasStatefulLayer(t.Layers()) {
+			return true
+		}
+	}
+	return false
+}
+
+//Train trains the model given trainX and  trainY data and the number of epochs. It keeps track of the defined metrics and prints it every epoch. It also prints the training duration.
+//It is a shorthand for Fit with shuffled batches of 32 samples.
+//It returns a map from strings to floats, where strings represent the metrics name and float the metrics value after the last epoch.
+func (m *Model) Train(trainX, trainY *tensor.Tensor, epochs int) (map[string]float64, error) {
+	history, err := m.Fit(trainX, trainY, FitConfig{Epochs: epochs, BatchSize: defaultBatchSize, Shuffle: true, Seed: time.Now().UnixNano(), Verbose: true})
+	if err != nil {
+		return nil, err
+	}
+	metricsValues := make(map[string]float64, len(m.modelMetrics))
+	for _, met := range m.modelMetrics {
+		metricsValues[met.Name()], _ = history.Last(met.Name())
+	}
+	return metricsValues, nil
+}
+
+//Summary prints the layer by layer summaary along with trainable parameters and the others: the parameters of frozen
+//layers and those layers update themselves, like moving statistics. For a functional model it prints every node with
+//the layers it takes its inputs from.
 func (m *Model) Summary() {
-	var sum int
+	if m.functional {
+		m.graphSummary()
+		return
+	}
+	var sum, frozen int
+	var shape []int
+	if len(m.layers) > 0 {
+		if in, ok := m.layers[0].(*InputLayer); ok {
+			shape = in.Shape()
+		}
+	}
 	for i := range m.layers {
+		if shape != nil {
+			var err error
+			if shape, err = m.layers[i].Build(shape); err != nil {
+				fmt.Println(err)
+				shape = nil
+			}
+		}
 		tp := m.layers[i].TrainableParameters()
-		sum += tp
-		fmt.Printf("name: %s		trainable parameters: %d\n", m.layers[i].Name(), tp)
+		ntp := countValues(m.layers[i].Parameters()) - tp
+		sum, frozen = sum+tp, frozen+ntp
+		fmt.Printf("name: %s		output shape: %v		trainable parameters: %d		non-trainable parameters: %d\n", m.layers[i].Name(), shape, tp, ntp)
+	}
+	fmt.Println("Trainable parameters: ", sum)
+	fmt.Println("Non-trainable parameters: ", frozen)
+}
+
+//graphSummary prints the summary of a functional model. The parameters of a shared layer are counted once.
+func (m *Model) graphSummary() {
+	g, err := m.network()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, in := range g.inputs {
+		fmt.Printf("name: %s		output shape: %v		trainable parameters: 0		non-trainable parameters: 0\n", in.layer.Name(), in.shape)
+	}
+	var sum, frozen int
+	counted := map[Layer]bool{}
+	for _, n := range g.nodes {
+		inputs := make([]string, len(n.inbound))
+		for i, in := range n.inbound {
+			inputs[i] = in.name()
+		}
+		var tp, ntp int
+		if !counted[n.layer] {
+			counted[n.layer] = true
+			tp = n.layer.TrainableParameters()
+			ntp = countValues(n.layer.Parameters()) - tp
+			sum, frozen = sum+tp, frozen+ntp
+		}
+		fmt.Printf("name: %s		output shape: %v		trainable parameters: %d		non-trainable parameters: %d		inputs: %v\n", n.name(), n.shape, tp, ntp, inputs)
 	}
 	fmt.Println("Trainable parameters: ", sum)
+	fmt.Println("Non-trainable parameters: ", frozen)
 }
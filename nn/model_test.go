@@ -0,0 +1,961 @@
+package neuralnetwork
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+func mustTensor(t *testing.T, a interface{}) *tensor.Tensor {
+	t.Helper()
+	x, err := tensor.NewTensor(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestDenseBackwardMatchesNumericGradient(t *testing.T) {
+	x := mustTensor(t, [][]float64{{0.5, -1, 2}, {1.5, 0.3, -0.7}})
+	y := mustTensor(t, [][]float64{{1, 0}, {0, 1}})
+	model := Sequential([]Layer{
+		Dense(4, Tanh),
+		Dense(2, Sigmoid),
+	}, "grad")
+	model.Compile(SGD(0), MeanSquaredError{}, nil)
+
+	if _, err := model.Build([]int{3}); err != nil {
+		t.Fatal(err)
+	}
+	params := model.Parameters()
+	pred, err := model.Predict(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grad, _ := model.loss.Gradient(pred, y)
+	if err := model.backward(grad); err != nil {
+		t.Fatal(err)
+	}
+
+	lossAt := func() float64 {
+		pred, _ := model.Predict(x)
+		l, _ := model.loss.Compute(pred, y)
+		return l
+	}
+	const eps = 1e-6
+	for _, p := range params {
+		v := p.Value.Data()
+		for i := range v {
+			orig := v[i]
+			v[i] = orig + eps
+			up := lossAt()
+			v[i] = orig - eps
+			down := lossAt()
+			v[i] = orig
+			numeric := (up - down) / (2 * eps)
+			if got := p.Grad.Data()[i]; math.Abs(got-numeric) > 1e-5 {
+				t.Errorf("%s[%d]: backward %v, numeric %v", p.Name, i, got, numeric)
+			}
+		}
+	}
+}
+
+func TestTrainReducesLoss(t *testing.T) {
+	x := mustTensor(t, [][]float64{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {0.5, 0.5}, {0.2, 0.9}})
+	y := mustTensor(t, [][]float64{{0}, {-1}, {2}, {1}, {0.5}, {-0.5}})
+	model := Sequential([]Layer{
+		Dense(8, Tanh),
+		Dense(1, Linear),
+	}, "regression")
+	model.Compile(SGD(0.1), MeanSquaredError{}, nil)
+
+	pred, _ := model.Predict(x)
+	before, _ := model.loss.Compute(pred, y)
+	if _, err := model.Train(x, y, 300); err != nil {
+		t.Fatal(err)
+	}
+	pred, _ = model.Predict(x)
+	after, _ := model.loss.Compute(pred, y)
+	if after > before/10 {
+		t.Errorf("loss went from %v to %v, expected the model to learn", before, after)
+	}
+}
+
+func TestFitMiniBatchesAreReproducible(t *testing.T) {
+	rows := make([][]float64, 50)
+	targets := make([][]float64, 50)
+	for i := range rows {
+		a, b := float64(i%7)/7, float64(i%5)/5
+		rows[i] = []float64{a, b}
+		targets[i] = []float64{a - 2*b}
+	}
+	x, y := mustTensor(t, rows), mustTensor(t, targets)
+	fit := func() *History {
+		model := Sequential([]Layer{Dense(4, Tanh), Dense(1, Linear)}, "fit")
+		model.Compile(SGD(0.05), MeanSquaredError{}, nil)
+		if _, err := model.Build([]int{2}); err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range model.Parameters() {
+			d := p.Value.Data()
+			for j := range d {
+				d[j] = math.Sin(float64(i*10 + j))
+			}
+		}
+		h, err := model.Fit(x, y, FitConfig{Epochs: 20, BatchSize: 8, Shuffle: true, Seed: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	first, second := fit(), fit()
+	if len(first.Values["loss"]) != 20 {
+		t.Fatalf("history has %d epochs, want 20", len(first.Values["loss"]))
+	}
+	for i := range first.Values["loss"] {
+		if first.Values["loss"][i] != second.Values["loss"][i] {
+			t.Fatalf("epoch %d: %v != %v, the same seed should give the same batches", i, first.Values["loss"][i], second.Values["loss"][i])
+		}
+	}
+	if l := first.Values["loss"]; l[len(l)-1] >= l[0] {
+		t.Errorf("loss did not decrease: %v", l)
+	}
+}
+
+//maxError is a test metric.
+type maxError struct{}
+
+func (maxError) Measure(pred, truth []float64) float64 {
+	var m float64
+	for i := range pred {
+		m = math.Max(m, math.Abs(pred[i]-truth[i]))
+	}
+	return m
+}
+
+func (maxError) Name() string { return "max_error" }
+
+func TestFitValidationAndEarlyStopping(t *testing.T) {
+	rows := make([][]float64, 20)
+	targets := make([][]float64, 20)
+	for i := range rows {
+		rows[i] = []float64{float64(i) / 20}
+		targets[i] = []float64{float64(i) / 10}
+	}
+	x, y := mustTensor(t, rows), mustTensor(t, targets)
+	model := Sequential([]Layer{Dense(1, Linear)}, "val")
+	model.Compile(SGD(0), MeanSquaredError{}, []Metrics{maxError{}})
+
+	stopper := EarlyStopping("val_loss", 2, 0, "auto")
+	h, err := model.Fit(x, y, FitConfig{Epochs: 10, BatchSize: 4, ValidationSplit: 0.25, Callbacks: []Callback{stopper}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"loss", "max_error", "val_loss", "val_max_error"} {
+		if _, ok := h.Last(name); !ok {
+			t.Errorf("history is missing %s", name)
+		}
+	}
+	if len(h.Epochs) != 3 {
+		t.Errorf("trained %d epochs, early stopping should stop after 3 without improvement", len(h.Epochs))
+	}
+
+	valX, _ := x.Gather(15, 20, 0)
+	valY, _ := y.Gather(15, 20, 0)
+	want, err := model.evaluate([]*tensor.Tensor{valX}, []*tensor.Tensor{valY}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := h.Last("val_loss"); math.Abs(got-want["loss"]) > 1e-12 {
+		t.Errorf("val_loss = %v, want the loss on the last 25%% of the samples %v", got, want["loss"])
+	}
+
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 1, Callbacks: []Callback{EarlyStopping("val_loss", 1, 0, "min")}}); err == nil {
+		t.Error("expected an error when monitoring val_loss without validation data")
+	}
+	if model.training {
+		t.Error("the model is still training after Fit returned an error")
+	}
+}
+
+func TestPredictAndEvaluateInBatches(t *testing.T) {
+	rows := make([][]float64, 70)
+	for i := range rows {
+		rows[i] = []float64{float64(i), float64(-i) / 3}
+	}
+	x := mustTensor(t, rows)
+	model := Sequential([]Layer{Dense(3, Tanh), Dropout(0.5), Dense(2, Linear)}, "predict")
+	model.Compile(SGD(0), MeanSquaredError{}, []Metrics{maxError{}})
+
+	whole, err := model.forward(x, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batched, err := model.PredictBatched(x, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalShapes(batched.Shape(), []int{70, 2}) {
+		t.Fatalf("prediction shape = %v, want [70 2]", batched.Shape())
+	}
+	for i, v := range whole.Data() {
+		if math.Abs(batched.Data()[i]-v) > 1e-12 {
+			t.Fatalf("batched prediction differs at %d: %v != %v", i, batched.Data()[i], v)
+		}
+	}
+
+	logs, err := model.Evaluate(x, whole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logs["loss"] > 1e-20 || logs["max_error"] > 1e-10 {
+		t.Errorf("evaluating on the model's own inference predictions should give zero loss, got %v", logs)
+	}
+}
+
+func TestConvolutionalModelTrains(t *testing.T) {
+	//classify 6x6 images by whether their bright square is in the left or the right half
+	var images [][][][]float64
+	var labels [][]float64
+	for r := 0; r < 5; r++ {
+		for c := 0; c < 5; c++ {
+			img := make([][][]float64, 6)
+			for i := range img {
+				img[i] = make([][]float64, 6)
+				for j := range img[i] {
+					img[i][j] = []float64{0}
+				}
+			}
+			img[r][c][0], img[r+1][c][0], img[r][c+1][0], img[r+1][c+1][0] = 1, 1, 1, 1
+			images = append(images, img)
+			if c < 2 {
+				labels = append(labels, []float64{1, 0})
+			} else {
+				labels = append(labels, []float64{0, 1})
+			}
+		}
+	}
+	x, y := mustTensor(t, images), mustTensor(t, labels)
+	conv := Conv2D(4, 3, 1, Same)
+	conv.Activation = Relu
+	model := Sequential([]Layer{
+		Input([]int{6, 6, 1}),
+		conv,
+		MaxPooling2D(2),
+		Flatten(),
+		Dense(2, Linear),
+		Softmax(),
+	}, "cnn")
+	model.Compile(Adam(0.01), CategoricalCrossEntropy{}, nil)
+	h, err := model.Fit(x, y, FitConfig{Epochs: 60, BatchSize: 5, Shuffle: true, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := h.Values["loss"]; l[len(l)-1] > l[0]/4 {
+		t.Errorf("loss went from %v to %v, expected the model to learn", l[0], l[len(l)-1])
+	}
+}
+
+func TestRecurrentModelTrains(t *testing.T) {
+	//predict the mean of a sequence of five values
+	var seqs [][][]float64
+	var targets [][]float64
+	for i := 0; i < 32; i++ {
+		seq := make([][]float64, 5)
+		var sum float64
+		for j := range seq {
+			v := math.Sin(float64(i*5 + j*3))
+			seq[j] = []float64{v}
+			sum += v
+		}
+		seqs = append(seqs, seq)
+		targets = append(targets, []float64{sum / 5})
+	}
+	x, y := mustTensor(t, seqs), mustTensor(t, targets)
+	for _, rnn := range []Layer{SimpleRNN(6, Tanh), LSTM(6), GRU(6)} {
+		model := Sequential([]Layer{rnn, Dense(1, Linear)}, "rnn")
+		model.Compile(Adam(0.02), MeanSquaredError{}, nil)
+		h, err := model.Fit(x, y, FitConfig{Epochs: 40, BatchSize: 8, Shuffle: true, Seed: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l := h.Values["loss"]; l[len(l)-1] > l[0]/4 {
+			t.Errorf("%s: loss went from %v to %v, expected the model to learn", rnn.Name(), l[0], l[len(l)-1])
+		}
+	}
+}
+
+func TestMaskZeroSkipsPadding(t *testing.T) {
+	embedding := Embedding(6, 4)
+	embedding.MaskZero = true
+	lstm := LSTM(3)
+	//the layers between the embedding and the LSTM pass the mask on
+	for _, model := range []*Model{
+		Sequential([]Layer{embedding, lstm}, "masked"),
+		Sequential([]Layer{embedding, Dropout(0.5), Dense(4, Tanh), lstm}, "masked_through"),
+	} {
+		padded, err := model.forward(mustTensor(t, [][]float64{{3, 5, 0, 0}, {0, 2, 0, 4}}), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		short, err := model.forward(mustTensor(t, [][]float64{{3, 5}, {2, 4}}), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range short.Data() {
+			if math.Abs(padded.Data()[i]-v) > 1e-12 {
+				t.Fatalf("%s: padded outputs %v, want the outputs without padding %v", model.name, padded.Data(), short.Data())
+			}
+		}
+	}
+}
+
+func TestStatefulFit(t *testing.T) {
+	rng := rand.New(rand.NewSource(37))
+	x, y := randomTensor(rng, []int{8, 3, 2}), randomTensor(rng, []int{8, 2})
+	lstm := LSTM(2)
+	lstm.Stateful = true
+	model := Sequential([]Layer{Input([]int{3, 2}), lstm}, "stateful")
+	model.Compile(SGD(0.01), MeanSquaredError{}, nil)
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 1, BatchSize: 4, ValidationSplit: 0.25}); err == nil || !strings.Contains(err.Error(), "multiples of the batch size") {
+		t.Errorf("got error %v, want one for 6 training and 2 validation samples in batches of 4", err)
+	}
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 2, BatchSize: 4, ValidationSplit: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	//validation and prediction leave the states of training unchanged, whatever their batch size
+	states := append([]float64(nil), lstm.states[1]...)
+	valX, _ := x.Gather(4, 8, 0)
+	valY, _ := y.Gather(4, 8, 0)
+	if _, err := model.evaluate([]*tensor.Tensor{valX.Contiguous()}, []*tensor.Tensor{valY.Contiguous()}, 4); err != nil {
+		t.Fatal(err)
+	}
+	first3, _ := x.Gather(0, 3, 0)
+	if _, err := model.Predict(first3.Contiguous()); err != nil {
+		t.Errorf("predicting 3 samples after training in batches of 4: %v", err)
+	}
+	for i, v := range lstm.states[1] {
+		if v != states[i] {
+			t.Fatalf("inference moved the cell state from %v to %v", states, lstm.states[1])
+		}
+	}
+}
+
+func TestTransformerModelTrains(t *testing.T) {
+	//tell whether token 1 appears in a sequence of up to six tokens padded with zeros
+	rng := rand.New(rand.NewSource(4))
+	var seqs, labels [][]float64
+	for i := 0; i < 48; i++ {
+		seq := make([]float64, 6)
+		n := 2 + rng.Intn(5)
+		for j := 0; j < n; j++ {
+			seq[j] = float64(2 + rng.Intn(4))
+		}
+		label := []float64{1, 0}
+		if i%2 == 0 {
+			seq[rng.Intn(n)] = 1
+			label = []float64{0, 1}
+		}
+		seqs, labels = append(seqs, seq), append(labels, label)
+	}
+	x, y := mustTensor(t, seqs), mustTensor(t, labels)
+	embedding := Embedding(6, 8)
+	embedding.MaskZero = true
+	model := Sequential([]Layer{
+		embedding,
+		SinusoidalPositionalEncoding(),
+		TransformerEncoder(2, 4, 16),
+		GlobalAveragePooling1D(),
+		Dense(2, Linear),
+		Softmax(),
+	}, "transformer")
+	model.Compile(Adam(0.01), CategoricalCrossEntropy{}, nil)
+	h, err := model.Fit(x, y, FitConfig{Epochs: 30, BatchSize: 8, Shuffle: true, Seed: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := h.Values["loss"]; l[len(l)-1] > l[0]/4 {
+		t.Errorf("loss went from %v to %v, expected the model to learn", l[0], l[len(l)-1])
+	}
+}
+
+func TestFusedSoftmaxCrossEntropy(t *testing.T) {
+	rng := rand.New(rand.NewSource(19))
+	x := randomTensor(rng, []int{6, 4})
+	y := tensor.Zeros([]int{6, 3})
+	for i := 0; i < 6; i++ {
+		y.Data()[i*3+i%3] = 1
+	}
+	fused := Sequential([]Layer{Dense(3, Linear), Softmax()}, "fused")
+	fused.Compile(SGD(0.5), CategoricalCrossEntropy{}, nil)
+	logits := Sequential([]Layer{Dense(3, Linear)}, "logits")
+	logits.Compile(SGD(0.5), CategoricalCrossEntropy{FromLogits: true}, nil)
+	if _, err := fused.Build([]int{4}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := logits.Build([]int{4}); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range logits.Parameters() {
+		copy(p.Value.Data(), fused.Parameters()[i].Value.Data())
+	}
+	for step := 0; step < 5; step++ {
+		a, err := fused.trainStep([]*tensor.Tensor{x}, []*tensor.Tensor{y}, 1, map[string]float64{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := logits.trainStep([]*tensor.Tensor{x}, []*tensor.Tensor{y}, 1, map[string]float64{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(a-b) > 1e-9 {
+			t.Fatalf("step %d: softmax with cross entropy lost %v, cross entropy from logits %v", step, a, b)
+		}
+	}
+	for i, p := range logits.Parameters() {
+		for j, v := range p.Value.Data() {
+			if math.Abs(v-fused.Parameters()[i].Value.Data()[j]) > 1e-9 {
+				t.Fatalf("%s differs after training", p.Name)
+			}
+		}
+	}
+}
+
+func TestFunctionalGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(23))
+	a, b := Input([]int{3}), Input([]int{2, 3})
+	shared := Dense(3, Tanh)
+	//a residual block, a layer shared between both inputs and every merge layer
+	h := Apply(Dense(3, Tanh), a.Output())
+	res := Apply(Add(), a.Output(), Apply(shared, h))
+	seq := Apply(shared, b.Output())
+	gate := Apply(Multiply(), Apply(Flatten(), seq), Apply(Dense(6, Sigmoid), res))
+	avg := Apply(Average(), Apply(Dense(6, Linear), res), gate)
+	out1 := Apply(Dense(2, Linear), Apply(Concatenate(), avg, res))
+	out2 := Apply(Softmax(), Apply(Dense(3, Linear), gate))
+	model, err := Functional([]*Node{a.Output(), b.Output()}, []*Node{out1, out2}, "functional")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.CompileOutputs(SGD(0), map[string]Loss{
+		out1.Layer().Name(): MeanSquaredError{},
+		out2.Layer().Name(): CategoricalCrossEntropy{},
+	}, map[string]float64{out2.Layer().Name(): 0.5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	x := []*tensor.Tensor{randomTensor(rng, []int{4, 3}), randomTensor(rng, []int{4, 2, 3})}
+	y := []*tensor.Tensor{randomTensor(rng, []int{4, 2}), tensor.Zeros([]int{4, 3})}
+	for i := 0; i < 4; i++ {
+		y[1].Data()[i*3+i%3] = 1
+	}
+	if _, err := model.trainStep(x, y, 1, map[string]float64{}); err != nil {
+		t.Fatal(err)
+	}
+	lossAt := func() float64 {
+		logs, err := model.evaluate(x, y, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logs["loss"]
+	}
+	const eps = 1e-6
+	for _, p := range model.Parameters() {
+		v := p.Value.Data()
+		for i := range v {
+			orig := v[i]
+			v[i] = orig + eps
+			up := lossAt()
+			v[i] = orig - eps
+			down := lossAt()
+			v[i] = orig
+			numeric := (up - down) / (2 * eps)
+			if got := p.Grad.Data()[i]; math.Abs(got-numeric) > 1e-5 {
+				t.Errorf("%s[%d]: backward %v, numeric %v", p.Name, i, got, numeric)
+			}
+		}
+	}
+	if len(model.Parameters()) != 12 {
+		t.Errorf("got %d parameters, the shared layer should be counted once", len(model.Parameters()))
+	}
+}
+
+func TestSharedStatefulLayers(t *testing.T) {
+	rng := rand.New(rand.NewSource(29))
+	in := Input([]int{4})
+	drop := Dropout(0.5)
+	drop.SetSeed(3)
+	dropped, err := newGraph([]*Node{in.Output()}, []*Node{Apply(Add(), Apply(drop, in.Output()), Apply(drop, in.Output()))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := dropped.run([]*tensor.Tensor{tensor.Ones([]int{2, 4})}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grads := map[*Node]*tensor.Tensor{dropped.outputs[0]: tensor.Ones([]int{2, 4})}
+	if err := dropped.backward(grads); err != nil {
+		t.Fatal(err)
+	}
+	//the outputs are the inputs times the sum of both masks, so are the gradients for inputs of ones
+	for i, v := range grads[in.Output()].Data() {
+		if v != out[0].Data()[i] {
+			t.Fatalf("shared dropout: gradients %v do not follow the masks of outputs %v", grads[in.Output()].Data(), out[0].Data())
+		}
+	}
+
+	bn := BatchNorm()
+	normalized, err := newGraph([]*Node{in.Output()}, []*Node{Apply(Add(), Apply(bn, in.Output()), Apply(bn, Apply(Dense(4, Tanh), in.Output())))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, dy := randomTensor(rng, []int{3, 4}), randomTensor(rng, []int{3, 4})
+	if _, err := normalized.run([]*tensor.Tensor{x}, true); err != nil {
+		t.Fatal(err)
+	}
+	mean := append([]float64(nil), bn.movingMean.Value.Data()...)
+	grads = map[*Node]*tensor.Tensor{normalized.outputs[0]: dy}
+	if err := normalized.backward(grads); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range bn.movingMean.Value.Data() {
+		if v != mean[i] {
+			t.Fatalf("shared batch normalization: the backward pass moved the moving mean from %v to %v", mean, bn.movingMean.Value.Data())
+		}
+	}
+	lossAt := func() float64 {
+		out, err := normalized.run([]*tensor.Tensor{x}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum float64
+		for i, v := range out[0].Data() {
+			sum += v * dy.Data()[i]
+		}
+		return sum
+	}
+	const eps = 1e-6
+	for i, got := range grads[in.Output()].Data() {
+		orig := x.Data()[i]
+		x.Data()[i] = orig + eps
+		up := lossAt()
+		x.Data()[i] = orig - eps
+		down := lossAt()
+		x.Data()[i] = orig
+		if numeric := (up - down) / (2 * eps); math.Abs(got-numeric) > 1e-5 {
+			t.Errorf("shared batch normalization: input[%d] backward %v, numeric %v", i, got, numeric)
+		}
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+	source, target := Input([]int{4, 3}), Input([]int{3, 2})
+	encoder, decoder := LSTM(5), LSTM(5)
+	encoder.ReturnState, decoder.ReturnSequences = true, true
+	encoded := Apply(encoder, source.Output())
+	states := encoded.ExtraOutputs()
+	if len(states) != 2 || !equalShapes(states[1].Shape(), []int{5}) {
+		t.Fatalf("got %d states, want the hidden and the cell states of shape [5]", len(states))
+	}
+	if n := Apply(decoder, target.Output(), states[0]); n.Err() == nil {
+		t.Error("expected an error for an LSTM given one initial state")
+	}
+	out := Apply(Dense(2, Linear), Apply(decoder, target.Output(), states[0], states[1]))
+	model, err := Functional([]*Node{source.Output(), target.Output()}, []*Node{out, states[1]}, "seq2seq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := model.OutputNames()
+	if names[1] != encoder.Name()+":2" {
+		t.Errorf("the cell state is named %s, want %s:2", names[1], encoder.Name())
+	}
+	if err := model.CompileOutputs(SGD(0), map[string]Loss{names[0]: MeanSquaredError{}, names[1]: MeanSquaredError{}}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	x := []*tensor.Tensor{randomTensor(rng, []int{3, 4, 3}), randomTensor(rng, []int{3, 3, 2})}
+	y := []*tensor.Tensor{randomTensor(rng, []int{3, 3, 2}), randomTensor(rng, []int{3, 5})}
+	preds, err := model.net.run(x, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range encoder.States()[1].Data() {
+		if preds[1].Data()[i] != v {
+			t.Fatalf("state output %v, want the final cell state %v", preds[1].Data(), encoder.States()[1].Data())
+		}
+	}
+	if _, err := model.trainStep(x, y, 1, map[string]float64{}); err != nil {
+		t.Fatal(err)
+	}
+	lossAt := func() float64 {
+		logs, err := model.evaluate(x, y, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logs["loss"]
+	}
+	//the encoder is trained through the states it hands to the decoder as much as through its own output
+	const eps = 1e-6
+	for _, p := range model.Parameters() {
+		v := p.Value.Data()
+		for i := range v {
+			orig := v[i]
+			v[i] = orig + eps
+			up := lossAt()
+			v[i] = orig - eps
+			down := lossAt()
+			v[i] = orig
+			if numeric := (up - down) / (2 * eps); math.Abs(p.Grad.Data()[i]-numeric) > 1e-5 {
+				t.Errorf("%s[%d]: backward %v, numeric %v", p.Name, i, p.Grad.Data()[i], numeric)
+			}
+		}
+	}
+}
+
+//bufferedScaleLayer is a scaleLayer keeping its inputs in a buffer it reuses from call to call, which it saves and
+//restores as a StateSaver.
+type bufferedScaleLayer struct {
+	scaleLayer
+}
+
+func (s *bufferedScaleLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	if _, err := s.Build(inputs.Shape()[1:]); err != nil {
+		return nil, err
+	}
+	if s.inputs == nil || !equalShapes(s.inputs.Shape(), inputs.Shape()) {
+		s.inputs = tensor.Zeros(inputs.Shape())
+	}
+	copy(s.inputs.Data(), inputs.Contiguous().Data())
+	return inputs.Multiply(s.scale.Value)
+}
+
+func (s *bufferedScaleLayer) State() interface{} {
+	return s.inputs.Clone()
+}
+
+func (s *bufferedScaleLayer) SetState(state interface{}) {
+	copy(s.inputs.Data(), state.(*tensor.Tensor).Data())
+}
+
+func TestSharedLayerStateSaver(t *testing.T) {
+	rng := rand.New(rand.NewSource(41))
+	in := Input([]int{3})
+	scale := &bufferedScaleLayer{scaleLayer{BaseLayer: NewBaseLayer("buffered_scale")}}
+	g, err := newGraph([]*Node{in.Output()}, []*Node{Apply(Add(), Apply(scale, in.Output()), Apply(scale, Apply(Dense(3, Tanh), in.Output())))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, dy := randomTensor(rng, []int{2, 3}), randomTensor(rng, []int{2, 3})
+	if _, err := g.run([]*tensor.Tensor{x}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.backward(map[*Node]*tensor.Tensor{g.outputs[0]: dy}); err != nil {
+		t.Fatal(err)
+	}
+	lossAt := func() float64 {
+		out, err := g.run([]*tensor.Tensor{x}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum float64
+		for i, v := range out[0].Data() {
+			sum += v * dy.Data()[i]
+		}
+		return sum
+	}
+	const eps = 1e-6
+	v := scale.scale.Value.Data()
+	for i, got := range scale.scale.Grad.Data() {
+		orig := v[i]
+		v[i] = orig + eps
+		up := lossAt()
+		v[i] = orig - eps
+		down := lossAt()
+		v[i] = orig
+		if numeric := (up - down) / (2 * eps); math.Abs(got-numeric) > 1e-6 {
+			t.Errorf("scale[%d]: backward %v, numeric %v", i, got, numeric)
+		}
+	}
+}
+
+func TestFunctionalErrors(t *testing.T) {
+	a, b := Input([]int{3}), Input([]int{4})
+	bad := Apply(Dense(2, Linear), Apply(Add(), a.Output(), b.Output()))
+	if bad.Err() == nil {
+		t.Fatal("expected an error adding inputs of shapes [3] and [4]")
+	}
+	if _, err := Functional([]*Node{a.Output(), b.Output()}, []*Node{bad}, "bad"); err == nil {
+		t.Error("Functional should report the error of its outputs")
+	}
+	if n := Apply(Dense(2, Linear), a.Output(), b.Output()); n.Err() == nil {
+		t.Error("expected an error applying a single input layer to two nodes")
+	}
+	unconnected := Apply(Dense(2, Linear), b.Output())
+	if _, err := Functional([]*Node{a.Output()}, []*Node{unconnected}, "unconnected"); err == nil {
+		t.Error("expected an error for an output that does not depend on the inputs")
+	}
+	model, err := Functional([]*Node{a.Output()}, []*Node{Apply(Dense(2, Linear), a.Output())}, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.CompileOutputs(SGD(0.1), map[string]Loss{"missing": MeanSquaredError{}}, nil, nil); err == nil {
+		t.Error("expected an error for a loss of an unknown output")
+	}
+}
+
+func TestMultiTaskModelTrains(t *testing.T) {
+	rng := rand.New(rand.NewSource(29))
+	n := 64
+	features, labels, values := tensor.Zeros([]int{n, 4}), tensor.Zeros([]int{n, 2}), tensor.Zeros([]int{n, 1})
+	for i := 0; i < n; i++ {
+		var s float64
+		for j := 0; j < 4; j++ {
+			v := rng.NormFloat64()
+			features.Data()[i*4+j] = v
+			s += v
+		}
+		if s > 0 {
+			labels.Data()[i*2] = 1
+		} else {
+			labels.Data()[i*2+1] = 1
+		}
+		values.Data()[i] = s / 2
+	}
+	in := Input([]int{4})
+	in.SetName("features")
+	trunk := Apply(Dense(8, Tanh), in.Output())
+	class := Dense(2, Linear)
+	class.SetName("class_logits")
+	value := Dense(1, Linear)
+	value.SetName("value")
+	classOut := Apply(Softmax(), Apply(class, trunk))
+	classOut.Layer().(*SoftmaxLayer).SetName("class")
+	model, err := Functional([]*Node{in.Output()}, []*Node{classOut, Apply(value, Apply(Add(), trunk, trunk))}, "multitask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.CompileOutputs(Adam(0.02), map[string]Loss{"class": CategoricalCrossEntropy{}, "value": MeanSquaredError{}},
+		map[string]float64{"value": 0.5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	x, y := Data{"features": features}, Data{"class": labels, "value": values}
+	h, err := model.FitData(x, y, FitConfig{Epochs: 40, BatchSize: 16, Shuffle: true, Seed: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"loss", "class_loss", "value_loss"} {
+		if first, last := h.Values[name][0], h.Values[name][len(h.Epochs)-1]; last > first/2 {
+			t.Errorf("%s went from %v to %v", name, first, last)
+		}
+	}
+	if got, want := h.Values["loss"][0], h.Values["class_loss"][0]+0.5*h.Values["value_loss"][0]; math.Abs(got-want) > 1e-9 {
+		t.Errorf("loss %v is not the weighted sum of the output losses %v", got, want)
+	}
+	pred, err := model.PredictData(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalShapes(pred["class"].Shape(), []int{n, 2}) || !equalShapes(pred["value"].Shape(), []int{n, 1}) {
+		t.Errorf("predictions have shapes %v and %v", pred["class"].Shape(), pred["value"].Shape())
+	}
+	if _, err := model.EvaluateData(x, Data{"class": labels}); err == nil {
+		t.Error("expected an error for missing targets")
+	}
+}
+
+func TestSequentialAdd(t *testing.T) {
+	model := Sequential(nil, "added").Add(Dense(4, Tanh)).Add(Dense(1, Linear))
+	model.Compile(SGD(0.1), MeanSquaredError{}, nil)
+	x := mustTensor(t, [][]float64{{0, 1}, {1, 0}, {1, 1}})
+	y := mustTensor(t, [][]float64{{1}, {1}, {0}})
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(model.Parameters()) != 4 {
+		t.Errorf("got %d parameters, want the kernels and biases of both layers", len(model.Parameters()))
+	}
+}
+
+func TestTransferLearning(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	x, y := randomTensor(rng, []int{16, 3}), randomTensor(rng, []int{16, 1})
+	dense, bn := Dense(4, Tanh), BatchNorm()
+	base := Sequential([]Layer{Input([]int{3}), dense, bn, Dense(1, Linear)}, "base")
+	base.Compile(SGD(0.1), MeanSquaredError{}, nil)
+	if _, err := base.Fit(x, y, FitConfig{Epochs: 2, BatchSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+	base.SetTrainable(false)
+	if dense.Trainable() || dense.TrainableParameters() != 0 || bn.TrainableParameters() != 0 {
+		t.Error("the layers of a frozen model should have no trainable parameters")
+	}
+
+	head := Dense(1, Linear)
+	model := Sequential([]Layer{Input([]int{3}), dense, bn, head}, "transfer")
+	model.Compile(SGD(0.1), MeanSquaredError{}, nil)
+	if err := model.autoBuild(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := func(params []*Parameter) []float64 {
+		var values []float64
+		for _, p := range params {
+			values = append(values, p.Value.Data()...)
+		}
+		return values
+	}
+	frozen, headBefore := snapshot(append(dense.Parameters(), bn.Parameters()...)), snapshot(head.Parameters())
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 2, BatchSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range snapshot(append(dense.Parameters(), bn.Parameters()...)) {
+		if v != frozen[i] {
+			t.Fatal("training changed the parameters of frozen layers")
+		}
+	}
+	if headAfter := snapshot(head.Parameters()); headAfter[0] == headBefore[0] {
+		t.Error("the new layers did not train")
+	}
+	h := randomTensor(rng, []int{8, 4})
+	train, err := bn.Forward(h, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infer, err := bn.Forward(h, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range train.Data() {
+		if v != infer.Data()[i] {
+			t.Fatal("a frozen batch normalization should use its moving statistics while training")
+		}
+	}
+
+	model.SetTrainable(true)
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 1, BatchSize: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot(dense.Parameters())[0] == frozen[0] {
+		t.Error("unfrozen layers did not train")
+	}
+
+	//sublayers created when the block is built are frozen with it
+	e := TransformerEncoder(1, 2, 3)
+	e.SetTrainable(false)
+	if _, err := e.Build([]int{3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if e.TrainableParameters() != 0 || len(e.Parameters()) == 0 {
+		t.Errorf("a frozen encoder has %d trainable parameters, want 0", e.TrainableParameters())
+	}
+}
+
+func TestRegularizers(t *testing.T) {
+	rng := rand.New(rand.NewSource(29))
+	hidden, out := Dense(4, Tanh), Dense(2, Linear)
+	hidden.KernelRegularizer, hidden.BiasRegularizer, hidden.ActivityRegularizer = L1L2(0.01, 0.02), L2(0.05), L1(0.03)
+	out.KernelRegularizer = L2(0.1)
+	model := Sequential([]Layer{Input([]int{3}), hidden, out}, "regularized")
+	model.Compile(SGD(0), MeanSquaredError{}, nil)
+	x, y := []*tensor.Tensor{randomTensor(rng, []int{5, 3})}, []*tensor.Tensor{randomTensor(rng, []int{5, 2})}
+	lossAt := func(logs map[string]float64) float64 {
+		loss, err := model.trainStep(x, y, 1, logs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loss
+	}
+	logs := map[string]float64{}
+	loss := lossAt(logs)
+	scores, err := model.evaluate(x, y, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if penalty := logs["regularization_loss"]; penalty <= 0 || logs["loss"] != loss {
+		t.Errorf("loss %v logged as %v with a penalty of %v, want a positive penalty", loss, logs["loss"], penalty)
+	}
+	//the evaluation of the same data before the update includes the same penalty
+	if math.Abs(scores["loss"]-loss) > 1e-9 || math.Abs(scores["regularization_loss"]-logs["regularization_loss"]) > 1e-9 {
+		t.Errorf("evaluation loss %v with a penalty of %v, want the training loss %v with a penalty of %v",
+			scores["loss"], scores["regularization_loss"], loss, logs["regularization_loss"])
+	}
+	var grads [][]float64
+	for _, p := range model.Parameters() {
+		grads = append(grads, append([]float64(nil), p.Grad.Data()...))
+	}
+	const eps = 1e-6
+	for j, p := range model.Parameters() {
+		v := p.Value.Data()
+		for i := range v {
+			orig := v[i]
+			v[i] = orig + eps
+			up := lossAt(map[string]float64{})
+			v[i] = orig - eps
+			down := lossAt(map[string]float64{})
+			v[i] = orig
+			if numeric := (up - down) / (2 * eps); math.Abs(grads[j][i]-numeric) > 1e-5 {
+				t.Errorf("%s[%d]: backward %v, numeric %v", p.Name, i, grads[j][i], numeric)
+			}
+		}
+	}
+
+	plain := Sequential([]Layer{Input([]int{3}), Dense(2, Linear)}, "plain")
+	plain.Compile(SGD(0.1), MeanSquaredError{}, nil)
+	history, err := plain.Fit(x[0], y[0], FitConfig{Epochs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := history.Values["regularization_loss"]; ok {
+		t.Error("a model without regularizers should not log a regularization loss")
+	}
+}
+
+func TestRegularizerRoles(t *testing.T) {
+	lstm, embedding, encoder := LSTM(2), Embedding(5, 3), TransformerEncoder(1, 2, 3)
+	lstm.KernelRegularizer, lstm.RecurrentRegularizer = L2(1), L1(1)
+	embedding.EmbeddingsRegularizer = L2(1)
+	encoder.KernelRegularizer = L1(1)
+	encoder.Attention().KernelRegularizer = L2(1)
+	for _, build := range []struct {
+		layer Layer
+		shape []int
+	}{{lstm, []int{4, 3}}, {embedding, []int{4}}, {encoder, []int{4, 3}}} {
+		if _, err := build.layer.Build(build.shape); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the penalized weights, by the suffix of their names, and the regularizer of each
+	want := []struct {
+		layer    Layer
+		suffixes map[string]Regularizer
+	}{
+		{lstm, map[string]Regularizer{"/kernel": L2(1), "/recurrent_kernel": L1(1)}},
+		{embedding, map[string]Regularizer{"/embeddings": L2(1)}},
+		//the kernels of the sublayers take the regularizer of the block unless they set their own
+		{encoder, map[string]Regularizer{"/attention/query/kernel": L2(1), "/attention/key/kernel": L2(1),
+			"/attention/value/kernel": L2(1), "/attention/output/kernel": L2(1), "/hidden/kernel": L1(1), "/output/kernel": L1(1)}},
+	}
+	for _, w := range want {
+		var expected float64
+		matched := 0
+		for _, p := range w.layer.Parameters() {
+			for suffix, r := range w.suffixes {
+				if strings.HasSuffix(p.Name, w.layer.Name()+suffix) {
+					expected += r.Penalty(p.Value)
+					matched++
+				}
+			}
+		}
+		if matched != len(w.suffixes) {
+			t.Fatalf("%s: matched %d weights, want %d", w.layer.Name(), matched, len(w.suffixes))
+		}
+		penalty, applied, err := w.layer.(regularized).regularize(nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !applied || math.Abs(penalty-expected) > 1e-9 {
+			t.Errorf("%s: penalty %v, want %v", w.layer.Name(), penalty, expected)
+		}
+	}
+}
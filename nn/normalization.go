@@ -0,0 +1,248 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//groupNorm normalizes a contiguous buffer seen as [outer, inner, channels] where the channels are split into groups:
+//the mean and variance are computed over the inner positions and the channels of one group, separately for every
+//outer index. Layer normalization over the last axis is one group with an inner size of 1.
+type groupNorm struct {
+	outer, inner, channels, groups int
+	xhat, invStd                   []float64
+}
+
+//normalize returns the normalized values and remembers what backward needs.
+func (n *groupNorm) normalize(x []float64, epsilon float64) []float64 {
+	size := n.channels / n.groups
+	count := float64(n.inner * size)
+	n.xhat = make([]float64, len(x))
+	n.invStd = make([]float64, n.outer*n.groups)
+	for o := 0; o < n.outer; o++ {
+		for g := 0; g < n.groups; g++ {
+			var mean, variance float64
+			n.each(o, g, func(i int) { mean += x[i] })
+			mean /= count
+			n.each(o, g, func(i int) { variance += (x[i] - mean) * (x[i] - mean) })
+			inv := 1 / math.Sqrt(variance/count+epsilon)
+			n.each(o, g, func(i int) { n.xhat[i] = (x[i] - mean) * inv })
+			n.invStd[o*n.groups+g] = inv
+		}
+	}
+	return n.xhat
+}
+
+//backward returns the gradient with respect to the inputs given the gradient with respect to the normalized values.
+func (n *groupNorm) backward(dxhat []float64) []float64 {
+	size := n.channels / n.groups
+	count := float64(n.inner * size)
+	dx := make([]float64, len(dxhat))
+	for o := 0; o < n.outer; o++ {
+		for g := 0; g < n.groups; g++ {
+			var sum, dot float64
+			n.each(o, g, func(i int) {
+				sum += dxhat[i]
+				dot += dxhat[i] * n.xhat[i]
+			})
+			inv := n.invStd[o*n.groups+g]
+			n.each(o, g, func(i int) { dx[i] = inv * (dxhat[i] - sum/count - n.xhat[i]*dot/count) })
+		}
+	}
+	return dx
+}
+
+//each calls fn with the index of every element of group g of the outer index o.
+func (n *groupNorm) each(o, g int, fn func(i int)) {
+	size := n.channels / n.groups
+	for p := 0; p < n.inner; p++ {
+		base := (o*n.inner+p)*n.channels + g*size
+		for c := 0; c < size; c++ {
+			fn(base + c)
+		}
+	}
+}
+
+//normalization is the machinery shared by the normalization layers that do not depend on the batch: it normalizes
+//groups of elements of every sample and applies a learnable scale and shift per channel, the last axis.
+type normalization struct {
+	BaseLayer
+	gamma, beta *Parameter
+	norm        groupNorm
+	inputShape  []int
+	layout      func(shape []int) (outer, inner, groups int, err error)
+	Epsilon     float64
+	Center      bool
+	Scale       bool
+}
+
+func newNormalization(name string, layout func(shape []int) (int, int, int, error)) normalization {
+	return normalization{BaseLayer: newBaseLayer(name), layout: layout, Epsilon: 1e-3, Center: true, Scale: true}
+}
+
+//Build creates gamma and beta, one value per channel.
+func (n *normalization) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) == 0 {
+		return nil, fmt.Errorf("%s: expected inputs with at least one feature axis", n.name)
+	}
+	if _, _, _, err := n.layout(withBatch(1, inputShape)); err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	channels := inputShape[len(inputShape)-1]
+	if n.gamma == nil && n.beta == nil {
+		if n.Scale {
+			n.gamma = n.AddWeight("gamma", OtherWeight, []int{channels}, OnesInitializer, true)
+		}
+		if n.Center {
+			n.beta = n.AddWeight("beta", OtherWeight, []int{channels}, ZeroInitializer, true)
+		}
+	}
+	for _, p := range n.Parameters() {
+		if p.Value.Size() != channels {
+			return nil, fmt.Errorf("%s: expected %d channels, got shape %v", n.name, p.Value.Size(), inputShape)
+		}
+	}
+	return append([]int(nil), inputShape...), nil
+}
+
+//Forward normalizes the inputs, then scales and shifts every channel.
+func (n *normalization) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	if _, err := n.Build(shape); err != nil {
+		return nil, err
+	}
+	outer, inner, groups, err := n.layout(inputs.Shape())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	channels := shape[len(shape)-1]
+	n.norm = groupNorm{outer: outer, inner: inner, channels: channels, groups: groups}
+	xhat := n.norm.normalize(inputs.Data(), n.Epsilon)
+	out := make([]float64, len(xhat))
+	copy(out, xhat)
+	if n.gamma != nil {
+		gamma := n.gamma.Value.Data()
+		for i := range out {
+			out[i] *= gamma[i%channels]
+		}
+	}
+	if n.beta != nil {
+		beta := n.beta.Value.Data()
+		for i := range out {
+			out[i] += beta[i%channels]
+		}
+	}
+	n.inputShape = inputs.Shape()
+	return tensor.FromSlice(out, n.inputShape)
+}
+
+//Backward accumulates the gradients of gamma and beta and returns the gradient with respect to the inputs.
+func (n *normalization) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if n.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", n.name)
+	}
+	g := gradOutput.Data()
+	if len(g) != len(n.norm.xhat) {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", n.name, gradOutput.Shape())
+	}
+	channels := n.norm.channels
+	dxhat := g
+	if n.gamma != nil {
+		gamma := n.gamma.Value.Data()
+		gammaGrad := make([]float64, channels)
+		dxhat = make([]float64, len(g))
+		for i, v := range g {
+			gammaGrad[i%channels] += v * n.norm.xhat[i]
+			dxhat[i] = v * gamma[i%channels]
+		}
+		if err := accumulateData(n.gamma, gammaGrad); err != nil {
+			return nil, err
+		}
+	}
+	if n.beta != nil {
+		betaGrad := make([]float64, channels)
+		for i, v := range g {
+			betaGrad[i%channels] += v
+		}
+		if err := accumulateData(n.beta, betaGrad); err != nil {
+			return nil, err
+		}
+	}
+	return tensor.FromSlice(n.norm.backward(dxhat), n.inputShape)
+}
+
+//lastAxis is the layout of layer normalization, which normalizes every position of every sample over its last axis.
+func lastAxis(shape []int) (outer, inner, groups int, err error) {
+	outer = 1
+	for _, s := range shape[:len(shape)-1] {
+		outer *= s
+	}
+	return outer, 1, 1, nil
+}
+
+//newLayerNorm returns a layer normalization named name with epsilon 1e-3 and a learnable scale and shift.
+func newLayerNorm(name string) *normalization {
+	n := newNormalization(name, lastAxis)
+	return &n
+}
+
+//LayerNormalizationLayer normalizes every position of every sample over its last axis, independently of the batch.
+type LayerNormalizationLayer struct {
+	normalization
+}
+
+//LayerNormalization returns a layer normalization layer with epsilon 1e-3 and a learnable scale and shift.
+func LayerNormalization() *LayerNormalizationLayer {
+	return &LayerNormalizationLayer{newNormalization(uniqueName("layer_normalization"), lastAxis)}
+}
+
+//groupLayout returns the layout of inputs of shape [batch, spatial..., channels] normalized over the spatial axes and
+//groups of channels; groups of 0 means one group per channel.
+func groupLayout(groups int) func(shape []int) (int, int, int, error) {
+	return func(shape []int) (int, int, int, error) {
+		if len(shape) < 2 {
+			return 0, 0, 0, fmt.Errorf("expected inputs of shape [batch, ..., channels], got %v", shape)
+		}
+		channels, inner := shape[len(shape)-1], 1
+		for _, s := range shape[1 : len(shape)-1] {
+			inner *= s
+		}
+		g := groups
+		if g == 0 {
+			g = channels
+		}
+		if g < 1 || channels%g != 0 {
+			return 0, 0, 0, fmt.Errorf("%d channels cannot be split into %d groups", channels, g)
+		}
+		return shape[0], inner, g, nil
+	}
+}
+
+//GroupNormalizationLayer normalizes every sample over its spatial axes and groups of channels, independently of the
+//batch, which suits convolutional networks trained on small batches. Inputs have shape [batch, spatial..., channels].
+type GroupNormalizationLayer struct {
+	normalization
+}
+
+//GroupNormalization returns a group normalization layer splitting the channels into the given number of groups,
+//with epsilon 1e-3 and a learnable scale and shift per channel.
+func GroupNormalization(groups int) *GroupNormalizationLayer {
+	return &GroupNormalizationLayer{newNormalization(uniqueName("group_normalization"), groupLayout(groups))}
+}
+
+//InstanceNormalizationLayer normalizes every channel of every sample over its spatial axes. It is group
+//normalization with one group per channel.
+type InstanceNormalizationLayer struct {
+	normalization
+}
+
+//InstanceNormalization returns an instance normalization layer with epsilon 1e-3 and a learnable scale and shift
+//per channel.
+func InstanceNormalization() *InstanceNormalizationLayer {
+	return &InstanceNormalizationLayer{newNormalization(uniqueName("instance_normalization"), groupLayout(0))}
+}
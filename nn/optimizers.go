@@ -0,0 +1,264 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+)
+
+//OptimizerState is the state an optimizer accumulates during training. Slots holds the per-parameter values, such as
+//the moment estimates of Adam, keyed by slot name and then by parameter name. It can be encoded as JSON.
+type OptimizerState struct {
+	LearningRate float64                         `json:"learning_rate"`
+	Iterations   int                             `json:"iterations"`
+	Slots        map[string]map[string][]float64 `json:"slots"`
+}
+
+//StatefulOptimizer is implemented by optimizers whose state can be inspected and restored, which every optimizer of
+//this package does. Restoring the state saved in a checkpoint resumes training exactly where it left off.
+type StatefulOptimizer interface {
+	Optimizer
+	State() OptimizerState
+	LoadState(state OptimizerState) error
+}
+
+//optimizerBase holds the learning rate, the step count and the slots shared by all optimizers.
+type optimizerBase struct {
+	learningRate float64
+	iterations   int
+	slots        map[string]map[string][]float64
+}
+
+func newOptimizerBase(learningRate float64) optimizerBase {
+	return optimizerBase{learningRate: learningRate, slots: map[string]map[string][]float64{}}
+}
+
+//LearningRate returns the current learning rate.
+func (o *optimizerBase) LearningRate() float64 {
+	return o.learningRate
+}
+
+//SetLearningRate changes the learning rate used by the following updates.
+func (o *optimizerBase) SetLearningRate(lr float64) {
+	o.learningRate = lr
+}
+
+//Iterations returns the number of ApplyGradients calls so far.
+func (o *optimizerBase) Iterations() int {
+	return o.iterations
+}
+
+//State returns a copy of the optimizer's state.
+func (o *optimizerBase) State() OptimizerState {
+	return OptimizerState{LearningRate: o.learningRate, Iterations: o.iterations, Slots: copySlots(o.slots)}
+}
+
+//LoadState replaces the optimizer's state with a copy of state.
+func (o *optimizerBase) LoadState(state OptimizerState) error {
+	if state.Iterations < 0 {
+		return fmt.Errorf("invalid optimizer state: %d iterations", state.Iterations)
+	}
+	o.learningRate = state.LearningRate
+	o.iterations = state.Iterations
+	o.slots = copySlots(state.Slots)
+	return nil
+}
+
+func copySlots(src map[string]map[string][]float64) map[string]map[string][]float64 {
+	dst := make(map[string]map[string][]float64, len(src))
+	for name, values := range src {
+		dst[name] = make(map[string][]float64, len(values))
+		for param, v := range values {
+			dst[name][param] = append([]float64(nil), v...)
+		}
+	}
+	return dst
+}
+
+//slot returns the values of the named slot for p, creating them filled with init on first use.
+func (o *optimizerBase) slot(name string, p *Parameter, init float64) []float64 {
+	values, ok := o.slots[name]
+	if !ok {
+		values = map[string][]float64{}
+		o.slots[name] = values
+	}
+	s, ok := values[p.Name]
+	if !ok || len(s) != p.Value.Size() {
+		s = make([]float64, p.Value.Size())
+		for i := range s {
+			s[i] = init
+		}
+		values[p.Name] = s
+	}
+	return s
+}
+
+//SGDOptimizer implements stochastic gradient descent, optionally with momentum or Nesterov momentum.
+type SGDOptimizer struct {
+	optimizerBase
+	momentum float64
+	nesterov bool
+}
+
+//SGD returns plain stochastic gradient descent: w -= lr * g.
+func SGD(learningRate float64) *SGDOptimizer {
+	return &SGDOptimizer{optimizerBase: newOptimizerBase(learningRate)}
+}
+
+//Momentum returns gradient descent with momentum: v = momentum*v - lr*g, w += v.
+func Momentum(learningRate, momentum float64) *SGDOptimizer {
+	return &SGDOptimizer{optimizerBase: newOptimizerBase(learningRate), momentum: momentum}
+}
+
+//Nesterov returns gradient descent with Nesterov momentum: v = momentum*v - lr*g, w += momentum*v - lr*g.
+func Nesterov(learningRate, momentum float64) *SGDOptimizer {
+	return &SGDOptimizer{optimizerBase: newOptimizerBase(learningRate), momentum: momentum, nesterov: true}
+}
+
+//ApplyGradients updates every parameter with its gradient.
+func (o *SGDOptimizer) ApplyGradients(params []*Parameter) {
+	o.iterations++
+	for _, p := range params {
+		w, g := p.Value.Data(), p.Grad.Data()
+		if o.momentum == 0 {
+			for _, s := range p.spans() {
+				for i := s[0]; i < s[1]; i++ {
+					w[i] -= o.learningRate * g[i]
+				}
+			}
+			continue
+		}
+		v := o.slot("velocity", p, 0)
+		for _, s := range p.spans() {
+			for i := s[0]; i < s[1]; i++ {
+				v[i] = o.momentum*v[i] - o.learningRate*g[i]
+				if o.nesterov {
+					w[i] += o.momentum*v[i] - o.learningRate*g[i]
+				} else {
+					w[i] += v[i]
+				}
+			}
+		}
+	}
+}
+
+//AdamOptimizer implements Adam with bias corrected moment estimates. With a non-zero WeightDecay it is AdamW,
+//which decays the weights directly instead of adding the decay to the gradient.
+type AdamOptimizer struct {
+	optimizerBase
+	Beta1, Beta2, Epsilon float64
+	WeightDecay           float64
+}
+
+//Adam returns the Adam optimizer with beta1 0.9, beta2 0.999 and epsilon 1e-7.
+func Adam(learningRate float64) *AdamOptimizer {
+	return &AdamOptimizer{optimizerBase: newOptimizerBase(learningRate), Beta1: 0.9, Beta2: 0.999, Epsilon: 1e-7}
+}
+
+//AdamW returns Adam with decoupled weight decay: every step also performs w -= lr * weightDecay * w.
+func AdamW(learningRate, weightDecay float64) *AdamOptimizer {
+	o := Adam(learningRate)
+	o.WeightDecay = weightDecay
+	return o
+}
+
+//ApplyGradients updates every parameter with its gradient.
+func (o *AdamOptimizer) ApplyGradients(params []*Parameter) {
+	o.iterations++
+	t := float64(o.iterations)
+	correction1 := 1 - math.Pow(o.Beta1, t)
+	correction2 := 1 - math.Pow(o.Beta2, t)
+	for _, p := range params {
+		w, g := p.Value.Data(), p.Grad.Data()
+		m, v := o.slot("m", p, 0), o.slot("v", p, 0)
+		for _, s := range p.spans() {
+			for i := s[0]; i < s[1]; i++ {
+				m[i] = o.Beta1*m[i] + (1-o.Beta1)*g[i]
+				v[i] = o.Beta2*v[i] + (1-o.Beta2)*g[i]*g[i]
+				if o.WeightDecay != 0 {
+					w[i] -= o.learningRate * o.WeightDecay * w[i]
+				}
+				w[i] -= o.learningRate * (m[i] / correction1) / (math.Sqrt(v[i]/correction2) + o.Epsilon)
+			}
+		}
+	}
+}
+
+//RMSpropOptimizer divides the gradient by a moving average of its recent magnitude.
+type RMSpropOptimizer struct {
+	optimizerBase
+	Rho, Epsilon float64
+}
+
+//RMSprop returns the RMSprop optimizer with rho 0.9 and epsilon 1e-7.
+func RMSprop(learningRate float64) *RMSpropOptimizer {
+	return &RMSpropOptimizer{optimizerBase: newOptimizerBase(learningRate), Rho: 0.9, Epsilon: 1e-7}
+}
+
+//ApplyGradients updates every parameter with its gradient.
+func (o *RMSpropOptimizer) ApplyGradients(params []*Parameter) {
+	o.iterations++
+	for _, p := range params {
+		w, g := p.Value.Data(), p.Grad.Data()
+		v := o.slot("rms", p, 0)
+		for _, s := range p.spans() {
+			for i := s[0]; i < s[1]; i++ {
+				v[i] = o.Rho*v[i] + (1-o.Rho)*g[i]*g[i]
+				w[i] -= o.learningRate * g[i] / (math.Sqrt(v[i]) + o.Epsilon)
+			}
+		}
+	}
+}
+
+//AdagradOptimizer scales the learning rate of every weight by the inverse root of its accumulated squared gradients.
+type AdagradOptimizer struct {
+	optimizerBase
+	InitialAccumulator, Epsilon float64
+}
+
+//Adagrad returns the Adagrad optimizer with an initial accumulator of 0.1 and epsilon 1e-7.
+func Adagrad(learningRate float64) *AdagradOptimizer {
+	return &AdagradOptimizer{optimizerBase: newOptimizerBase(learningRate), InitialAccumulator: 0.1, Epsilon: 1e-7}
+}
+
+//ApplyGradients updates every parameter with its gradient.
+func (o *AdagradOptimizer) ApplyGradients(params []*Parameter) {
+	o.iterations++
+	for _, p := range params {
+		w, g := p.Value.Data(), p.Grad.Data()
+		acc := o.slot("accumulator", p, o.InitialAccumulator)
+		for _, s := range p.spans() {
+			for i := s[0]; i < s[1]; i++ {
+				acc[i] += g[i] * g[i]
+				w[i] -= o.learningRate * g[i] / (math.Sqrt(acc[i]) + o.Epsilon)
+			}
+		}
+	}
+}
+
+//AdadeltaOptimizer adapts the step size from moving averages of the squared gradients and of the squared updates.
+type AdadeltaOptimizer struct {
+	optimizerBase
+	Rho, Epsilon float64
+}
+
+//Adadelta returns the Adadelta optimizer with rho 0.95 and epsilon 1e-7. A learning rate of 1 matches the original paper.
+func Adadelta(learningRate float64) *AdadeltaOptimizer {
+	return &AdadeltaOptimizer{optimizerBase: newOptimizerBase(learningRate), Rho: 0.95, Epsilon: 1e-7}
+}
+
+//ApplyGradients updates every parameter with its gradient.
+func (o *AdadeltaOptimizer) ApplyGradients(params []*Parameter) {
+	o.iterations++
+	for _, p := range params {
+		w, g := p.Value.Data(), p.Grad.Data()
+		accGrad, accDelta := o.slot("accumulated_grad", p, 0), o.slot("accumulated_delta", p, 0)
+		for _, s := range p.spans() {
+			for i := s[0]; i < s[1]; i++ {
+				accGrad[i] = o.Rho*accGrad[i] + (1-o.Rho)*g[i]*g[i]
+				delta := math.Sqrt(accDelta[i]+o.Epsilon) / math.Sqrt(accGrad[i]+o.Epsilon) * g[i]
+				accDelta[i] = o.Rho*accDelta[i] + (1-o.Rho)*delta*delta
+				w[i] -= o.learningRate * delta
+			}
+		}
+	}
+}
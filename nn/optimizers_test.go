@@ -0,0 +1,168 @@
+package neuralnetwork
+
+import (
+	"math"
+	"testing"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//quadratic returns a parameter w = [3, -2] whose gradient is set for the loss sum(w^2)/2.
+func quadratic() *Parameter {
+	w, _ := tensor.NewTensor([]float64{3, -2})
+	return NewParameter("w", w)
+}
+
+func setQuadraticGrad(p *Parameter) {
+	p.Grad = p.Value.Clone()
+}
+
+func TestSGDVariants(t *testing.T) {
+	tests := []struct {
+		opt  Optimizer
+		want [2]float64 //value of w[0] after two steps
+	}{
+		//w1 = 3 - 0.1*3 = 2.7, w2 = 2.7 - 0.27 = 2.43
+		{SGD(0.1), [2]float64{2.7, 2.43}},
+		//v1 = -0.3, w1 = 2.7; v2 = 0.9*-0.3 - 0.27 = -0.54, w2 = 2.16
+		{Momentum(0.1, 0.9), [2]float64{2.7, 2.16}},
+		//v1 = -0.3, w1 = 3 - 0.27 - 0.3 = 2.43; v2 = -0.27 - 0.243 = -0.513, w2 = 2.43 - 0.4617 - 0.243 = 1.7253
+		{Nesterov(0.1, 0.9), [2]float64{2.43, 1.7253}},
+	}
+	for _, tt := range tests {
+		p := quadratic()
+		for step := 0; step < 2; step++ {
+			setQuadraticGrad(p)
+			tt.opt.ApplyGradients([]*Parameter{p})
+			if got := p.Value.Data()[0]; math.Abs(got-tt.want[step]) > 1e-9 {
+				t.Errorf("%T step %d: w = %v, want %v", tt.opt, step, got, tt.want[step])
+			}
+		}
+	}
+}
+
+func TestCallbacksChangeTheOptimizersLearningRate(t *testing.T) {
+	x := mustTensor(t, [][]float64{{1}, {2}})
+	y := mustTensor(t, [][]float64{{1}, {2}})
+	opt := SGD(0)
+	model := Sequential([]Layer{Dense(1, Linear)}, "lr")
+	model.Compile(opt, MeanSquaredError{}, nil)
+
+	schedule := LearningRateSchedule(func(epoch int, lr float64) float64 { return 0.5 + float64(epoch) })
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 3, Callbacks: []Callback{schedule}}); err != nil {
+		t.Fatal(err)
+	}
+	if opt.LearningRate() != 2.5 {
+		t.Errorf("learning rate = %v, want 2.5", opt.LearningRate())
+	}
+
+	//the parameters never change, so the loss stops improving after the first epoch
+	reduce := ReduceLr("loss", 0.5, 1, 0, 1e-3, "min")
+	model.Compile(frozenOptimizer{SGD(1)}, MeanSquaredError{}, nil)
+	if _, err := model.Fit(x, y, FitConfig{Epochs: 4, Callbacks: []Callback{reduce}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := model.optimizer.LearningRate(); got != 0.125 {
+		t.Errorf("learning rate after three plateaus = %v, want 0.125", got)
+	}
+}
+
+//frozenOptimizer keeps the learning rate bookkeeping of its embedded optimizer but never changes the parameters.
+type frozenOptimizer struct{ *SGDOptimizer }
+
+func (frozenOptimizer) ApplyGradients(params []*Parameter) {}
+
+func TestAdaptiveOptimizers(t *testing.T) {
+	tests := []struct {
+		opt  Optimizer
+		want float64 //value of w[0] after one step from w = g = 3
+	}{
+		//the bias corrected first step moves every weight by lr, whatever the gradient
+		{Adam(0.1), 3 - 0.1*3/(3+1e-7)},
+		//w -= lr*wd*w before the Adam step
+		{AdamW(0.1, 0.5), 3 - 0.15 - 0.1*3/(3+1e-7)},
+		//v = 0.1*9, w -= lr*g/sqrt(v)
+		{RMSprop(0.1), 3 - 0.1*3/(math.Sqrt(0.9)+1e-7)},
+		//acc = 0.1 + 9
+		{Adagrad(0.1), 3 - 0.1*3/(math.Sqrt(9.1)+1e-7)},
+		//accGrad = 0.05*9, delta = sqrt(1e-7)/sqrt(accGrad+1e-7)*g
+		{Adadelta(1), 3 - math.Sqrt(1e-7)/math.Sqrt(0.45+1e-7)*3},
+	}
+	for _, tt := range tests {
+		p := quadratic()
+		setQuadraticGrad(p)
+		tt.opt.ApplyGradients([]*Parameter{p})
+		if got := p.Value.Data()[0]; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%T: w = %v, want %v", tt.opt, got, tt.want)
+		}
+	}
+}
+
+func TestCheckpointResumesTraining(t *testing.T) {
+	rows := make([][]float64, 16)
+	targets := make([][]float64, 16)
+	for i := range rows {
+		a, b := float64(i%4)/4, float64(i/4)/4
+		rows[i] = []float64{a, b}
+		targets[i] = []float64{math.Sin(a + 2*b)}
+	}
+	x, y := mustTensor(t, rows), mustTensor(t, targets)
+	newModel := func(opt Optimizer) *Model {
+		model := Sequential([]Layer{Input([]int{2}), Dense(4, Tanh), Dense(1, Linear)}, "resume")
+		model.Compile(opt, MeanSquaredError{}, nil)
+		if err := model.autoBuild(); err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range model.Parameters() {
+			d := p.Value.Data()
+			for j := range d {
+				d[j] = math.Cos(float64(i*10 + j))
+			}
+		}
+		return model
+	}
+	cfg := FitConfig{Epochs: 3, BatchSize: 4}
+
+	for _, opt := range []func() StatefulOptimizer{
+		func() StatefulOptimizer { return Adam(0.01) },
+		func() StatefulOptimizer { return Nesterov(0.05, 0.9) },
+		func() StatefulOptimizer { return Adadelta(1) },
+	} {
+		straight := newModel(opt())
+		for i := 0; i < 2; i++ {
+			if _, err := straight.Fit(x, y, cfg); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		first := newModel(opt())
+		if _, err := first.Fit(x, y, cfg); err != nil {
+			t.Fatal(err)
+		}
+		path := t.TempDir() + "/model.ckpt"
+		if err := first.SaveCheckpoint(path, false); err != nil {
+			t.Fatal(err)
+		}
+		resumedOpt := opt()
+		resumed := Sequential([]Layer{Input([]int{2}), Dense(4, Tanh), Dense(1, Linear)}, "resume")
+		resumed.Compile(resumedOpt, MeanSquaredError{}, nil)
+		if err := resumed.LoadCheckpoint(path); err != nil {
+			t.Fatal(err)
+		}
+		if resumedOpt.State().Iterations != 12 {
+			t.Errorf("%T: restored %d iterations, want 12", resumedOpt, resumedOpt.State().Iterations)
+		}
+		if _, err := resumed.Fit(x, y, cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		want, got := straight.Parameters(), resumed.Parameters()
+		for i := range want {
+			for j, v := range want[i].Value.Data() {
+				if math.Abs(got[i].Value.Data()[j]-v) > 1e-12 {
+					t.Fatalf("%T: %s[%d] = %v after resuming, want %v", resumedOpt, got[i].Name, j, got[i].Value.Data()[j], v)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//pooling is the machinery shared by the pooling layers. It reduces every window of inputs of shape
+//[batch, spatial..., channels] to its maximum or its average, channel by channel.
+type pooling struct {
+	BaseLayer
+	rank       int
+	average    bool
+	win        *window
+	inputShape []int
+	argmax     []int //for max pooling, the input element every output element was taken from
+	PoolSize   []int
+	Strides    []int
+	Padding    Padding
+}
+
+func newPooling(rank, poolSize int, average bool, name string) pooling {
+	p := pooling{BaseLayer: NewBaseLayer(name), rank: rank, average: average, Padding: Valid}
+	for i := 0; i < rank; i++ {
+		p.PoolSize = append(p.PoolSize, poolSize)
+		p.Strides = append(p.Strides, poolSize)
+	}
+	return p
+}
+
+//Build validates the input shape and returns the shape of the pooled outputs.
+func (p *pooling) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != p.rank+1 {
+		return nil, fmt.Errorf("%s: expected inputs of rank %d without the batch axis, got shape %v", p.name, p.rank+1, inputShape)
+	}
+	if len(p.PoolSize) != p.rank || len(p.Strides) != p.rank {
+		return nil, fmt.Errorf("%s: pool size and strides must have %d values", p.name, p.rank)
+	}
+	if p.Padding == Causal {
+		return nil, fmt.Errorf("%s: pooling does not support causal padding", p.name)
+	}
+	var out, before, dilation []int
+	for i := 0; i < p.rank; i++ {
+		if p.PoolSize[i] < 1 || p.Strides[i] < 1 {
+			return nil, fmt.Errorf("%s: pool size and strides must be positive", p.name)
+		}
+		o, b, err := convPadding(p.Padding, inputShape[i], p.PoolSize[i], p.Strides[i], 1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p.name, err)
+		}
+		out, before, dilation = append(out, o), append(before, b), append(dilation, 1)
+	}
+	if p.win == nil || !equalShapes(p.win.inSize, inputShape[:p.rank]) || !equalShapes(p.win.outSize, out) {
+		p.win = newWindow(inputShape[:p.rank], p.PoolSize, p.Strides, dilation, before, out)
+	}
+	return append(out, inputShape[p.rank]), nil
+}
+
+//Forward pools every window. Padding never takes part: it is neither a candidate maximum nor counted in the average.
+func (p *pooling) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p.name, err)
+	}
+	outShape, err := p.Build(shape)
+	if err != nil {
+		return nil, err
+	}
+	batch, channels := inputs.Shape()[0], shape[p.rank]
+	x := inputs.Data()
+	outPositions, k := p.win.outPositions(), p.win.kernelPositions
+	out := make([]float64, batch*outPositions*channels)
+	if !p.average {
+		p.argmax = make([]int, len(out))
+	}
+	for b := 0; b < batch; b++ {
+		base := b * p.win.inPositions * channels
+		for o := 0; o < outPositions; o++ {
+			taps := p.win.taps[o*k : (o+1)*k]
+			for c := 0; c < channels; c++ {
+				i := (b*outPositions+o)*channels + c
+				if p.average {
+					var sum float64
+					var n int
+					for _, pos := range taps {
+						if pos >= 0 {
+							sum += x[base+pos*channels+c]
+							n++
+						}
+					}
+					out[i] = sum / float64(n)
+					continue
+				}
+				//start from the first input of the window so that windows of -Inf or NaN have an argmax too; NaN wins
+				arg := -1
+				for _, pos := range taps {
+					if pos < 0 {
+						continue
+					}
+					j := base + pos*channels + c
+					if arg < 0 || x[j] > x[arg] || (math.IsNaN(x[j]) && !math.IsNaN(x[arg])) {
+						arg = j
+					}
+				}
+				out[i], p.argmax[i] = x[arg], arg
+			}
+		}
+	}
+	p.inputShape = inputs.Shape()
+	return tensor.FromSlice(out, withBatch(batch, outShape))
+}
+
+//Backward routes the gradient of every output to the input it was taken from for max pooling, and spreads it evenly
+//over its window for average pooling.
+func (p *pooling) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if p.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", p.name)
+	}
+	batch, channels := p.inputShape[0], p.inputShape[len(p.inputShape)-1]
+	outPositions, k := p.win.outPositions(), p.win.kernelPositions
+	g := gradOutput.Data()
+	if len(g) != batch*outPositions*channels {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", p.name, gradOutput.Shape())
+	}
+	dx := make([]float64, batch*p.win.inPositions*channels)
+	if !p.average {
+		for i, arg := range p.argmax {
+			dx[arg] += g[i]
+		}
+		return tensor.FromSlice(dx, p.inputShape)
+	}
+	for b := 0; b < batch; b++ {
+		base := b * p.win.inPositions * channels
+		for o := 0; o < outPositions; o++ {
+			taps := p.win.taps[o*k : (o+1)*k]
+			var n int
+			for _, pos := range taps {
+				if pos >= 0 {
+					n++
+				}
+			}
+			for c := 0; c < channels; c++ {
+				share := g[(b*outPositions+o)*channels+c] / float64(n)
+				for _, pos := range taps {
+					if pos >= 0 {
+						dx[base+pos*channels+c] += share
+					}
+				}
+			}
+		}
+	}
+	return tensor.FromSlice(dx, p.inputShape)
+}
+
+//globalPooling pools over all the spatial axes at once and drops them.
+type globalPooling struct {
+	pooling
+}
+
+//Build validates the input shape and returns [channels].
+func (g *globalPooling) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != g.rank+1 {
+		return nil, fmt.Errorf("%s: expected inputs of rank %d without the batch axis, got shape %v", g.name, g.rank+1, inputShape)
+	}
+	g.PoolSize = append([]int(nil), inputShape[:g.rank]...)
+	g.Strides = g.PoolSize
+	if _, err := g.pooling.Build(inputShape); err != nil {
+		return nil, err
+	}
+	return inputShape[g.rank:], nil
+}
+
+//Forward returns a [batch, channels] tensor.
+func (g *globalPooling) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", g.name, err)
+	}
+	if _, err := g.Build(shape); err != nil {
+		return nil, err
+	}
+	out, err := g.pooling.Forward(inputs, training)
+	if err != nil {
+		return nil, err
+	}
+	return out.Reshape(inputs.Shape()[0], shape[g.rank])
+}
+
+//MaxPooling2DLayer takes the maximum of every window of inputs of shape [batch, height, width, channels].
+type MaxPooling2DLayer struct {
+	pooling
+}
+
+//MaxPooling2D returns a max pooling layer with square windows of size poolSize, moving by poolSize and without padding.
+//PoolSize, Strides and Padding can be changed before the layer is used.
+func MaxPooling2D(poolSize int) *MaxPooling2DLayer {
+	return &MaxPooling2DLayer{newPooling(2, poolSize, false, "max_pooling2d")}
+}
+
+//AveragePooling2DLayer averages every window of inputs of shape [batch, height, width, channels].
+type AveragePooling2DLayer struct {
+	pooling
+}
+
+//AveragePooling2D returns an average pooling layer with square windows of size poolSize, moving by poolSize and
+//without padding. PoolSize, Strides and Padding can be changed before the layer is used.
+func AveragePooling2D(poolSize int) *AveragePooling2DLayer {
+	return &AveragePooling2DLayer{newPooling(2, poolSize, true, "average_pooling2d")}
+}
+
+//GlobalMaxPooling2DLayer takes the maximum of every channel over the height and width, returning [batch, channels].
+type GlobalMaxPooling2DLayer struct {
+	globalPooling
+}
+
+//GlobalMaxPooling2D returns a global max pooling layer.
+func GlobalMaxPooling2D() *GlobalMaxPooling2DLayer {
+	return &GlobalMaxPooling2DLayer{globalPooling{newPooling(2, 1, false, "global_max_pooling2d")}}
+}
+
+//GlobalAveragePooling2DLayer averages every channel over the height and width, returning [batch, channels].
+type GlobalAveragePooling2DLayer struct {
+	globalPooling
+}
+
+//GlobalAveragePooling2D returns a global average pooling layer.
+func GlobalAveragePooling2D() *GlobalAveragePooling2DLayer {
+	return &GlobalAveragePooling2DLayer{globalPooling{newPooling(2, 1, true, "global_average_pooling2d")}}
+}
+
+//MaxPooling1DLayer takes the maximum of every window of sequences of shape [batch, steps, channels].
+type MaxPooling1DLayer struct {
+	pooling
+}
+
+//MaxPooling1D returns a max pooling layer with windows of size poolSize, moving by poolSize and without padding.
+func MaxPooling1D(poolSize int) *MaxPooling1DLayer {
+	return &MaxPooling1DLayer{newPooling(1, poolSize, false, "max_pooling1d")}
+}
+
+//AveragePooling1DLayer averages every window of sequences of shape [batch, steps, channels].
+type AveragePooling1DLayer struct {
+	pooling
+}
+
+//AveragePooling1D returns an average pooling layer with windows of size poolSize, moving by poolSize and without padding.
+func AveragePooling1D(poolSize int) *AveragePooling1DLayer {
+	return &AveragePooling1DLayer{newPooling(1, poolSize, true, "average_pooling1d")}
+}
+
+//GlobalMaxPooling1DLayer takes the maximum of every channel over the steps, returning [batch, channels].
+type GlobalMaxPooling1DLayer struct {
+	globalPooling
+}
+
+//GlobalMaxPooling1D returns a global max pooling layer for sequences.
+func GlobalMaxPooling1D() *GlobalMaxPooling1DLayer {
+	return &GlobalMaxPooling1DLayer{globalPooling{newPooling(1, 1, false, "global_max_pooling1d")}}
+}
+
+//GlobalAveragePooling1DLayer averages every channel over the steps, returning [batch, channels].
+type GlobalAveragePooling1DLayer struct {
+	globalPooling
+}
+
+//GlobalAveragePooling1D returns a global average pooling layer for sequences.
+func GlobalAveragePooling1D() *GlobalAveragePooling1DLayer {
+	return &GlobalAveragePooling1DLayer{globalPooling{newPooling(1, 1, true, "global_average_pooling1d")}}
+}
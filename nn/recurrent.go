@@ -0,0 +1,592 @@
+package neuralnetwork
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//cell is the step function of a recurrent layer. xw holds inputs x kernel + bias for one step, of shape
+//[batch, gates*units]; the cell adds the recurrent part itself. backstep receives the gradients of the new states, adds
+//the gradient of the recurrent kernel to the layer's recurrentGrad and returns the gradients of xw and of the previous
+//states.
+type cell interface {
+	gates() int
+	stateCount() int
+	step(r *recurrent, xw []float64, states [][]float64) ([][]float64, interface{})
+	backstep(r *recurrent, dStates [][]float64, cache interface{}) ([]float64, [][]float64)
+}
+
+//recurrent is the machinery shared by the recurrent layers. It runs a cell over inputs of shape
+//[batch, time, features] and backpropagates through time.
+type recurrent struct {
+	BaseLayer
+	units                   int
+	cell                    cell
+	kernel, recurrentKernel *Parameter
+	bias                    *Parameter
+	inputs                  []float64
+	batch, steps, features  int
+	caches                  []interface{}
+	recurrentGrad           []float64
+	states                  [][]float64 //carried by a stateful layer from one training batch to the next
+	final                   [][]float64 //the final states of the last call
+	initial                 bool
+	stateGrads              []*tensor.Tensor
+	mask                    *tensor.Tensor
+	stepMask                []float64
+	//ReturnSequences makes the layer return the output of every step, [batch, time, units], instead of the last one.
+	ReturnSequences bool
+	//ReturnState makes the final states extra outputs of the layer, which the ExtraOutputs of its node return in a
+	//functional model: the hidden state, followed by the cell state for an LSTM, each of shape [units]. Together with
+	//initial states given to Apply after the inputs, it connects an encoder to a decoder.
+	ReturnState bool
+	//Stateful makes every training batch start from the final states of the previous one instead of zeros. Batches
+	//must then have the same size; call ResetStates between independent sequences. Inference, validation included,
+	//starts from those states when its batches have the same size, from zeros otherwise, and leaves them unchanged.
+	Stateful      bool
+	KernelInit    func(float64) float64
+	RecurrentInit func(float64) float64
+	BiasInit      func(float64) float64
+}
+
+func newRecurrent(units int, c cell, name string) recurrent {
+	return recurrent{BaseLayer: NewBaseLayer(name),
+		units:         units,
+		cell:          c,
+		KernelInit:    HeUniform,
+		RecurrentInit: HeUniform,
+		BiasInit:      ZeroInitializer,
+	}
+}
+
+//Build creates a kernel of shape [features, gates*units], a recurrent kernel of shape [units, gates*units] and a bias.
+func (r *recurrent) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != 2 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [time, features], got %v", r.name, inputShape)
+	}
+	if c, ok := r.cell.(simpleCell); ok {
+		if _, err := c.activation.resolve(); err != nil {
+			return nil, fmt.Errorf("%s: %v", r.name, err)
+		}
+	}
+	width := r.cell.gates() * r.units
+	if r.kernel == nil {
+		r.kernel = r.AddWeight("kernel", KernelWeight, []int{inputShape[1], width}, r.KernelInit, true)
+		r.recurrentKernel = r.AddWeight("recurrent_kernel", RecurrentWeight, []int{r.units, width}, r.RecurrentInit, true)
+		r.bias = r.AddWeight("bias", BiasWeight, []int{width}, r.BiasInit, true)
+		if _, ok := r.cell.(lstmCell); ok {
+			//like keras' unit_forget_bias, start with the forget gate open
+			b := r.bias.Value.Data()
+			for i := r.units; i < 2*r.units; i++ {
+				b[i]++
+			}
+		}
+	} else if in := r.kernel.Value.Shape()[0]; in != inputShape[1] {
+		return nil, fmt.Errorf("%s: expected %d input features, got shape %v", r.name, in, inputShape)
+	}
+	if r.ReturnSequences {
+		return []int{inputShape[0], r.units}, nil
+	}
+	return []int{r.units}, nil
+}
+
+//BuildInputs builds the layer for the inputs, optionally followed by the initial states, each of shape [units]: the
+//hidden state, followed by the cell state for an LSTM.
+func (r *recurrent) BuildInputs(inputShapes [][]int) ([]int, error) {
+	if n := len(inputShapes); n != 1 && n != 1+r.cell.stateCount() {
+		return nil, fmt.Errorf("%s: expected the inputs followed by %d initial states or none, got %d inputs", r.name, r.cell.stateCount(), n)
+	}
+	for _, shape := range inputShapes[1:] {
+		if !equalShapes(shape, []int{r.units}) {
+			return nil, fmt.Errorf("%s: initial states must have shape [%d], got %v", r.name, r.units, shape)
+		}
+	}
+	return r.Build(inputShapes[0])
+}
+
+//Forward runs the cell over every step of the inputs.
+func (r *recurrent) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	return r.run(inputs, nil, training)
+}
+
+//ForwardInputs runs the cell over every step of the first input, starting from the initial states that follow it, if
+//any, instead of zeros or the states of a stateful layer.
+func (r *recurrent) ForwardInputs(inputs []*tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	if n := len(inputs); n != 1 && n != 1+r.cell.stateCount() {
+		return nil, fmt.Errorf("%s: expected the inputs followed by %d initial states or none, got %d inputs", r.name, r.cell.stateCount(), n)
+	}
+	var initial [][]float64
+	for _, s := range inputs[1:] {
+		initial = append(initial, s.Data())
+	}
+	return r.run(inputs[0], initial, training)
+}
+
+//run runs the cell over every step of the inputs, starting from the initial states unless they are nil.
+func (r *recurrent) run(inputs *tensor.Tensor, initial [][]float64, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.name, err)
+	}
+	outShape, err := r.Build(shape)
+	if err != nil {
+		return nil, err
+	}
+	batch, steps, features := inputs.Shape()[0], shape[0], shape[1]
+	states := r.states
+	if initial != nil {
+		for _, s := range initial {
+			if len(s) != batch*r.units {
+				return nil, fmt.Errorf("%s: initial states must have shape [%d %d], got %d values", r.name, batch, r.units, len(s))
+			}
+		}
+		states = initial
+	} else if !r.Stateful || states == nil || (!training && len(states[0]) != batch*r.units) {
+		states = make([][]float64, r.cell.stateCount())
+		for i := range states {
+			states[i] = make([]float64, batch*r.units)
+		}
+	} else if len(states[0]) != batch*r.units {
+		return nil, fmt.Errorf("%s: a stateful layer needs batches of %d samples, got %d", r.name, len(states[0])/r.units, batch)
+	}
+	var stepMask []float64
+	if r.mask != nil {
+		if !equalShapes(r.mask.Shape(), []int{batch, steps}) {
+			return nil, fmt.Errorf("%s: mask of shape %v does not match inputs of shape %v", r.name, r.mask.Shape(), inputs.Shape())
+		}
+		stepMask = r.mask.Data()
+	}
+	x := inputs.Data()
+	width := r.cell.gates() * r.units
+	w, b := r.kernel.Value.Data(), r.bias.Value.Data()
+	out := make([]float64, 0, batch*steps*r.units)
+	if !r.ReturnSequences {
+		out = out[:batch*r.units]
+	}
+	r.caches = make([]interface{}, steps)
+	for t := 0; t < steps; t++ {
+		xw := make([]float64, batch*width)
+		for i := 0; i < batch; i++ {
+			copy(xw[i*width:(i+1)*width], b)
+		}
+		gemm(batch, features, width, x[t*features:], steps*features, w, width, xw, width)
+		prev := states
+		states, r.caches[t] = r.cell.step(r, xw, states)
+		if r.ReturnSequences {
+			out = append(out, states[0]...)
+		}
+		for i := 0; stepMask != nil && i < batch; i++ {
+			if stepMask[i*steps+t] != 0 {
+				continue
+			}
+			//a padding step keeps the states and outputs zeros
+			for k := range states {
+				copy(states[k][i*r.units:(i+1)*r.units], prev[k][i*r.units:(i+1)*r.units])
+			}
+			if r.ReturnSequences {
+				row := out[(t*batch+i)*r.units : (t*batch+i+1)*r.units]
+				for u := range row {
+					row[u] = 0
+				}
+			}
+		}
+	}
+	if r.ReturnSequences {
+		//out is [time, batch, units], the outputs are [batch, time, units]
+		seq := make([]float64, len(out))
+		for t := 0; t < steps; t++ {
+			for i := 0; i < batch; i++ {
+				copy(seq[(i*steps+t)*r.units:(i*steps+t+1)*r.units], out[(t*batch+i)*r.units:(t*batch+i+1)*r.units])
+			}
+		}
+		out = seq
+	} else {
+		copy(out, states[0])
+	}
+	r.inputs, r.batch, r.steps, r.features = x, batch, steps, features
+	r.final, r.stepMask, r.initial = states, stepMask, initial != nil
+	if r.Stateful && training {
+		r.states = states
+	}
+	return tensor.FromSlice(out, withBatch(batch, outShape))
+}
+
+//Backward propagates the gradient through time, accumulates the gradients of the kernels and the bias and returns
+//the gradient with respect to the inputs. The states a stateful layer started from are treated as constants.
+func (r *recurrent) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	dx, _, err := r.backward(gradOutput)
+	return dx, err
+}
+
+//BackwardInputs returns the gradient with respect to the inputs, followed by those with respect to the initial
+//states if the last call was given any.
+func (r *recurrent) BackwardInputs(gradOutput *tensor.Tensor) ([]*tensor.Tensor, error) {
+	dx, dStates, err := r.backward(gradOutput)
+	if err != nil {
+		return nil, err
+	}
+	grads := []*tensor.Tensor{dx}
+	for i := 0; r.initial && i < len(dStates); i++ {
+		d, err := tensor.FromSlice(dStates[i], []int{r.batch, r.units})
+		if err != nil {
+			return nil, err
+		}
+		grads = append(grads, d)
+	}
+	return grads, nil
+}
+
+//backward propagates the gradient of the outputs, and those of the final states set with SetExtraGradients, through
+//time. It returns the gradients with respect to the inputs and to the states the last call started from.
+func (r *recurrent) backward(gradOutput *tensor.Tensor) (*tensor.Tensor, [][]float64, error) {
+	if r.caches == nil {
+		return nil, nil, fmt.Errorf("%s: Backward called before Forward", r.name)
+	}
+	batch, steps, features, units := r.batch, r.steps, r.features, r.units
+	g := gradOutput.Data()
+	if want := batch * units; (r.ReturnSequences && len(g) != want*steps) || (!r.ReturnSequences && len(g) != want) {
+		return nil, nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", r.name, gradOutput.Shape())
+	}
+	width := r.cell.gates() * units
+	w := r.kernel.Value.Data()
+	kernelGrad, biasGrad := make([]float64, features*width), make([]float64, width)
+	r.recurrentGrad = make([]float64, units*width)
+	dx := make([]float64, batch*steps*features)
+	dStates := make([][]float64, r.cell.stateCount())
+	for i := range dStates {
+		dStates[i] = make([]float64, batch*units)
+		if i < len(r.stateGrads) && r.stateGrads[i] != nil {
+			if r.stateGrads[i].Size() != batch*units {
+				return nil, nil, fmt.Errorf("%s: gradient of shape %v does not match the states", r.name, r.stateGrads[i].Shape())
+			}
+			copy(dStates[i], r.stateGrads[i].Data())
+		}
+	}
+	for t := steps - 1; t >= 0; t-- {
+		masked := func(i int) bool { return r.stepMask != nil && r.stepMask[i*steps+t] == 0 }
+		dh := dStates[0]
+		switch {
+		case r.ReturnSequences:
+			for i := 0; i < batch; i++ {
+				if masked(i) {
+					continue
+				}
+				for u := 0; u < units; u++ {
+					dh[i*units+u] += g[(i*steps+t)*units+u]
+				}
+			}
+		case t == steps-1:
+			for i := range dh {
+				dh[i] += g[i]
+			}
+		}
+		//the gradient of a padding step goes straight to the previous states
+		var carried [][]float64
+		for i := 0; i < batch; i++ {
+			if !masked(i) {
+				continue
+			}
+			for _, d := range dStates {
+				carried = append(carried, append([]float64(nil), d[i*units:(i+1)*units]...))
+				for u := i * units; u < (i+1)*units; u++ {
+					d[u] = 0
+				}
+			}
+		}
+		var dxw []float64
+		dxw, dStates = r.cell.backstep(r, dStates, r.caches[t])
+		for i := 0; i < batch; i++ {
+			if !masked(i) {
+				continue
+			}
+			for _, d := range dStates {
+				copy(d[i*units:(i+1)*units], carried[0])
+				carried = carried[1:]
+			}
+		}
+		gemmTA(batch, features, width, r.inputs[t*features:], steps*features, dxw, width, kernelGrad, width)
+		for i := 0; i < batch; i++ {
+			for j := 0; j < width; j++ {
+				biasGrad[j] += dxw[i*width+j]
+			}
+		}
+		gemmTB(batch, features, width, dxw, width, w, width, dx[t*features:], steps*features)
+	}
+	if err := accumulateData(r.kernel, kernelGrad); err != nil {
+		return nil, nil, err
+	}
+	if err := accumulateData(r.recurrentKernel, r.recurrentGrad); err != nil {
+		return nil, nil, err
+	}
+	if err := accumulateData(r.bias, biasGrad); err != nil {
+		return nil, nil, err
+	}
+	dxTensor, err := tensor.FromSlice(dx, []int{batch, steps, features})
+	return dxTensor, dStates, err
+}
+
+//accumulateData adds a gradient held in a flat buffer of the parameter's size to the parameter's gradient.
+func accumulateData(p *Parameter, g []float64) error {
+	t, err := tensor.FromSlice(g, p.Value.Shape())
+	if err != nil {
+		return err
+	}
+	return p.Accumulate(t)
+}
+
+//States returns the final states of the last Forward call, each of shape [batch, units]: the hidden state, followed by
+//the cell state for an LSTM. They are the extra outputs of a layer with ReturnState set.
+func (r *recurrent) States() []*tensor.Tensor {
+	var states []*tensor.Tensor
+	for _, s := range r.final {
+		t, _ := tensor.FromSlice(append([]float64(nil), s...), []int{len(s) / r.units, r.units})
+		states = append(states, t)
+	}
+	return states
+}
+
+//ExtraOutputShapes returns the shapes of the final states with ReturnState set, and nothing otherwise.
+func (r *recurrent) ExtraOutputShapes() [][]int {
+	if !r.ReturnState {
+		return nil
+	}
+	shapes := make([][]int, r.cell.stateCount())
+	for i := range shapes {
+		shapes[i] = []int{r.units}
+	}
+	return shapes
+}
+
+//ExtraOutputs returns the final states of the last Forward call with ReturnState set, and nothing otherwise.
+func (r *recurrent) ExtraOutputs() []*tensor.Tensor {
+	if !r.ReturnState {
+		return nil
+	}
+	return r.States()
+}
+
+//SetExtraGradients sets the gradients with respect to the final states for the next Backward call.
+func (r *recurrent) SetExtraGradients(grads []*tensor.Tensor) {
+	r.stateGrads = grads
+}
+
+//SetMask sets the [batch, time] mask of the following Forward calls. Steps whose mask is zero are padding: they
+//keep the states unchanged and, with ReturnSequences, output zeros.
+func (r *recurrent) SetMask(mask *tensor.Tensor) {
+	r.mask = mask
+}
+
+//Mask passes the mask on to the next layer when the layer returns sequences.
+func (r *recurrent) Mask() *tensor.Tensor {
+	if !r.ReturnSequences || r.stepMask == nil {
+		return nil
+	}
+	return r.mask
+}
+
+//stateful reports whether the layer carries its states from one batch to the next.
+func (r *recurrent) stateful() bool {
+	return r.Stateful
+}
+
+//ResetStates makes the next batch of a stateful layer start from zeros.
+func (r *recurrent) ResetStates() {
+	r.states = nil
+}
+
+//simpleCell computes h = activation(x W + h R + b).
+type simpleCell struct {
+	activation Activation
+}
+
+type simpleCache struct {
+	h, a []float64
+}
+
+func (simpleCell) gates() int      { return 1 }
+func (simpleCell) stateCount() int { return 1 }
+
+func (c simpleCell) step(r *recurrent, xw []float64, states [][]float64) ([][]float64, interface{}) {
+	batch := len(xw) / r.units
+	gemm(batch, r.units, r.units, states[0], r.units, r.recurrentKernel.Value.Data(), r.units, xw, r.units)
+	h := make([]float64, len(xw))
+	for i, a := range xw {
+		h[i] = c.activation.Func(a)
+	}
+	return [][]float64{h}, simpleCache{h: states[0], a: xw}
+}
+
+func (c simpleCell) backstep(r *recurrent, dStates [][]float64, cache interface{}) ([]float64, [][]float64) {
+	sc := cache.(simpleCache)
+	batch := len(sc.a) / r.units
+	da := make([]float64, len(sc.a))
+	for i, a := range sc.a {
+		da[i] = dStates[0][i] * c.activation.Derivative(a)
+	}
+	gemmTA(batch, r.units, r.units, sc.h, r.units, da, r.units, r.recurrentGrad, r.units)
+	dh := make([]float64, len(da))
+	gemmTB(batch, r.units, r.units, da, r.units, r.recurrentKernel.Value.Data(), r.units, dh, r.units)
+	return da, [][]float64{dh}
+}
+
+//lstmCell computes the input, forget, cell and output gates, in that order, as keras does.
+type lstmCell struct{}
+
+type lstmCache struct {
+	h, c       []float64
+	i, f, g, o []float64
+	newC       []float64
+}
+
+func (lstmCell) gates() int      { return 4 }
+func (lstmCell) stateCount() int { return 2 }
+
+func (lstmCell) step(r *recurrent, xw []float64, states [][]float64) ([][]float64, interface{}) {
+	u := r.units
+	batch := len(xw) / (4 * u)
+	gemm(batch, u, 4*u, states[0], u, r.recurrentKernel.Value.Data(), 4*u, xw, 4*u)
+	cache := lstmCache{h: states[0], c: states[1]}
+	cache.i, cache.f, cache.g, cache.o = make([]float64, batch*u), make([]float64, batch*u), make([]float64, batch*u), make([]float64, batch*u)
+	cache.newC = make([]float64, batch*u)
+	h := make([]float64, batch*u)
+	for n := 0; n < batch; n++ {
+		z := xw[n*4*u : (n+1)*4*u]
+		for k := 0; k < u; k++ {
+			j := n*u + k
+			cache.i[j], cache.f[j] = sigmoid(z[k]), sigmoid(z[u+k])
+			cache.g[j], cache.o[j] = math.Tanh(z[2*u+k]), sigmoid(z[3*u+k])
+			cache.newC[j] = cache.f[j]*states[1][j] + cache.i[j]*cache.g[j]
+			h[j] = cache.o[j] * math.Tanh(cache.newC[j])
+		}
+	}
+	return [][]float64{h, cache.newC}, cache
+}
+
+func (lstmCell) backstep(r *recurrent, dStates [][]float64, cache interface{}) ([]float64, [][]float64) {
+	lc := cache.(lstmCache)
+	u := r.units
+	batch := len(lc.h) / u
+	dz := make([]float64, batch*4*u)
+	dc := make([]float64, batch*u)
+	for n := 0; n < batch; n++ {
+		d := dz[n*4*u : (n+1)*4*u]
+		for k := 0; k < u; k++ {
+			j := n*u + k
+			tc := math.Tanh(lc.newC[j])
+			dh := dStates[0][j]
+			dcj := dStates[1][j] + dh*lc.o[j]*(1-tc*tc)
+			d[k] = dcj * lc.g[j] * lc.i[j] * (1 - lc.i[j])
+			d[u+k] = dcj * lc.c[j] * lc.f[j] * (1 - lc.f[j])
+			d[2*u+k] = dcj * lc.i[j] * (1 - lc.g[j]*lc.g[j])
+			d[3*u+k] = dh * tc * lc.o[j] * (1 - lc.o[j])
+			dc[j] = dcj * lc.f[j]
+		}
+	}
+	gemmTA(batch, u, 4*u, lc.h, u, dz, 4*u, r.recurrentGrad, 4*u)
+	dh := make([]float64, batch*u)
+	gemmTB(batch, u, 4*u, dz, 4*u, r.recurrentKernel.Value.Data(), 4*u, dh, u)
+	return dz, [][]float64{dh, dc}
+}
+
+//gruCell computes the update and reset gates and the candidate state, in that order, and applies the reset gate
+//before the recurrent kernel: h = z*h + (1-z)*tanh(x Wh + (r*h) Rh + bh).
+type gruCell struct{}
+
+type gruCache struct {
+	h, z, r, rh, candidate []float64
+}
+
+func (gruCell) gates() int      { return 3 }
+func (gruCell) stateCount() int { return 1 }
+
+func (gruCell) step(r *recurrent, xw []float64, states [][]float64) ([][]float64, interface{}) {
+	u := r.units
+	batch := len(xw) / (3 * u)
+	rk := r.recurrentKernel.Value.Data()
+	h := states[0]
+	gemm(batch, u, 2*u, h, u, rk, 3*u, xw, 3*u)
+	cache := gruCache{h: h, z: make([]float64, batch*u), r: make([]float64, batch*u), rh: make([]float64, batch*u), candidate: make([]float64, batch*u)}
+	for n := 0; n < batch; n++ {
+		for k := 0; k < u; k++ {
+			j := n*u + k
+			cache.z[j], cache.r[j] = sigmoid(xw[n*3*u+k]), sigmoid(xw[n*3*u+u+k])
+			cache.rh[j] = cache.r[j] * h[j]
+		}
+	}
+	gemm(batch, u, u, cache.rh, u, rk[2*u:], 3*u, xw[2*u:], 3*u)
+	newH := make([]float64, batch*u)
+	for n := 0; n < batch; n++ {
+		for k := 0; k < u; k++ {
+			j := n*u + k
+			cache.candidate[j] = math.Tanh(xw[n*3*u+2*u+k])
+			newH[j] = cache.z[j]*h[j] + (1-cache.z[j])*cache.candidate[j]
+		}
+	}
+	return [][]float64{newH}, cache
+}
+
+func (gruCell) backstep(r *recurrent, dStates [][]float64, cache interface{}) ([]float64, [][]float64) {
+	gc := cache.(gruCache)
+	u := r.units
+	batch := len(gc.h) / u
+	rk, rkGrad := r.recurrentKernel.Value.Data(), r.recurrentGrad
+	dxw := make([]float64, batch*3*u)
+	dh := make([]float64, batch*u)
+	for n := 0; n < batch; n++ {
+		for k := 0; k < u; k++ {
+			j := n*u + k
+			d := dStates[0][j]
+			dh[j] = d * gc.z[j]
+			dxw[n*3*u+k] = d * (gc.h[j] - gc.candidate[j]) * gc.z[j] * (1 - gc.z[j])
+			dxw[n*3*u+2*u+k] = d * (1 - gc.z[j]) * (1 - gc.candidate[j]*gc.candidate[j])
+		}
+	}
+	gemmTA(batch, u, u, gc.rh, u, dxw[2*u:], 3*u, rkGrad[2*u:], 3*u)
+	drh := make([]float64, batch*u)
+	gemmTB(batch, u, u, dxw[2*u:], 3*u, rk[2*u:], 3*u, drh, u)
+	for n := 0; n < batch; n++ {
+		for k := 0; k < u; k++ {
+			j := n*u + k
+			dh[j] += drh[j] * gc.r[j]
+			dxw[n*3*u+u+k] = drh[j] * gc.h[j] * gc.r[j] * (1 - gc.r[j])
+		}
+	}
+	gemmTA(batch, u, 2*u, gc.h, u, dxw, 3*u, rkGrad, 3*u)
+	gemmTB(batch, u, 2*u, dxw, 3*u, rk, 3*u, dh, u)
+	return dxw, [][]float64{dh}
+}
+
+//SimpleRNNLayer is a fully connected recurrent layer over inputs of shape [batch, time, features].
+type SimpleRNNLayer struct {
+	recurrent
+}
+
+//SimpleRNN returns a recurrent layer computing h = activation(x W + h R + b) at every step. The zero Activation is Tanh.
+func SimpleRNN(units int, activation Activation) *SimpleRNNLayer {
+	if activation.Func == nil && activation.Derivative == nil {
+		activation = Tanh
+	}
+	return &SimpleRNNLayer{newRecurrent(units, simpleCell{activation}, "simple_rnn")}
+}
+
+//LSTMLayer is a long short-term memory layer over inputs of shape [batch, time, features].
+type LSTMLayer struct {
+	recurrent
+}
+
+//LSTM returns a long short-term memory layer with sigmoid gates and tanh activations. The forget gate's bias starts at 1.
+func LSTM(units int) *LSTMLayer {
+	return &LSTMLayer{newRecurrent(units, lstmCell{}, "lstm")}
+}
+
+//GRULayer is a gated recurrent unit layer over inputs of shape [batch, time, features].
+type GRULayer struct {
+	recurrent
+}
+
+//GRU returns a gated recurrent unit layer with sigmoid gates and a tanh candidate state.
+func GRU(units int) *GRULayer {
+	return &GRULayer{newRecurrent(units, gruCell{}, "gru")}
+}
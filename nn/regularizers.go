@@ -0,0 +1,149 @@
+package neuralnetwork
+
+import (
+	"math"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//Regularizer penalizes large values of the weights or the outputs of a layer. Set it as the KernelRegularizer,
+//RecurrentRegularizer, BiasRegularizer, EmbeddingsRegularizer or ActivityRegularizer of the layer: its penalty is
+//added to the training and the validation losses and its gradient, while training, to the gradients of the backward
+//pass.
+type Regularizer interface {
+	Penalty(values *tensor.Tensor) float64
+	Gradient(values *tensor.Tensor) *tensor.Tensor
+}
+
+//L1L2Regularizer penalizes values with L1 times the sum of their absolute values plus L2 times the sum of their
+//squares.
+type L1L2Regularizer struct {
+	L1, L2 float64
+}
+
+//L1 returns a regularizer penalizing the sum of the absolute values, which drives small values to exactly zero.
+func L1(l1 float64) L1L2Regularizer {
+	return L1L2Regularizer{L1: l1}
+}
+
+//L2 returns a regularizer penalizing the sum of the squares, also known as weight decay.
+func L2(l2 float64) L1L2Regularizer {
+	return L1L2Regularizer{L2: l2}
+}
+
+//L1L2 returns a regularizer penalizing both the sum of the absolute values and the sum of the squares.
+func L1L2(l1, l2 float64) L1L2Regularizer {
+	return L1L2Regularizer{L1: l1, L2: l2}
+}
+
+//Penalty returns L1 * sum(|x|) + L2 * sum(x^2).
+func (r L1L2Regularizer) Penalty(values *tensor.Tensor) float64 {
+	var l1, l2 float64
+	for _, v := range values.Data() {
+		l1 += math.Abs(v)
+		l2 += v * v
+	}
+	return r.L1*l1 + r.L2*l2
+}
+
+//Gradient returns L1 * sign(x) + 2 * L2 * x. The gradient of |x| is taken to be zero at zero.
+func (r L1L2Regularizer) Gradient(values *tensor.Tensor) *tensor.Tensor {
+	return values.Map(func(v float64) float64 {
+		g := 2 * r.L2 * v
+		if v > 0 {
+			g += r.L1
+		} else if v < 0 {
+			g -= r.L1
+		}
+		return g
+	})
+}
+
+//regularized is implemented by the layers embedding a BaseLayer.
+type regularized interface {
+	regularize(inherited func(WeightRole) Regularizer, accumulate bool) (penalty float64, applied bool, err error)
+	activityRegularizer() Regularizer
+}
+
+//regularize returns the sum of the penalties of the weights and of those of the tracked layers, and adds their
+//gradients to the gradients of the weights if accumulate is set. A role without a regularizer of the layer falls back to
+//inherited, the regularizers of the layer tracking it. applied reports whether a regularizer applies at all.
+//Weights the optimizers do not update are not penalized.
+func (b *BaseLayer) regularize(inherited func(WeightRole) Regularizer, accumulate bool) (penalty float64, applied bool, err error) {
+	regularizer := func(role WeightRole) Regularizer {
+		var r Regularizer
+		switch role {
+		case KernelWeight:
+			r = b.KernelRegularizer
+		case RecurrentWeight:
+			r = b.RecurrentRegularizer
+		case BiasWeight:
+			r = b.BiasRegularizer
+		case EmbeddingsWeight:
+			r = b.EmbeddingsRegularizer
+		}
+		if r == nil && inherited != nil {
+			r = inherited(role)
+		}
+		return r
+	}
+	for i, w := range b.weights {
+		r := regularizer(b.roles[i])
+		if r == nil || !w.updatable() {
+			continue
+		}
+		penalty, applied = penalty+r.Penalty(w.Value), true
+		if !accumulate {
+			continue
+		}
+		if err := w.Accumulate(r.Gradient(w.Value)); err != nil {
+			return 0, false, err
+		}
+	}
+	p, a, err := regularizeLayers(b.layers, regularizer, accumulate)
+	return penalty + p, applied || a, err
+}
+
+//regularizeLayers regularizes the weights of the layers that support it.
+func regularizeLayers(layers []Layer, inherited func(WeightRole) Regularizer, accumulate bool) (penalty float64, applied bool, err error) {
+	for _, l := range layers {
+		if r, ok := l.(regularized); ok {
+			p, a, err := r.regularize(inherited, accumulate)
+			if err != nil {
+				return 0, false, err
+			}
+			penalty, applied = penalty+p, applied || a
+		}
+	}
+	return penalty, applied, nil
+}
+
+//activityRegularizer returns the regularizer of the outputs of the layer.
+func (b *BaseLayer) activityRegularizer() Regularizer {
+	return b.ActivityRegularizer
+}
+
+//regularize returns the sum of the penalties of the weights of the layers and of the outputs of the last run. Their
+//gradients are added to grads, and to the gradients of the weights, unless grads is nil. The penalty of the outputs
+//of a node is averaged over the batch. applied reports whether a regularizer applies at all.
+func (g *graph) regularize(grads map[*Node]*tensor.Tensor) (penalty float64, applied bool, err error) {
+	if penalty, applied, err = regularizeLayers(g.layers, nil, grads != nil); err != nil {
+		return 0, false, err
+	}
+	for _, n := range g.nodes {
+		r, ok := n.layer.(regularized)
+		if !ok || n.source != nil || r.activityRegularizer() == nil {
+			continue
+		}
+		out := g.values[n]
+		batch := batchSize(out)
+		penalty, applied = penalty+r.activityRegularizer().Penalty(out)/batch, true
+		if grads == nil {
+			continue
+		}
+		if err := addGradient(grads, n, r.activityRegularizer().Gradient(out).Scale(1/batch)); err != nil {
+			return 0, false, err
+		}
+	}
+	return penalty, applied, nil
+}
@@ -0,0 +1,226 @@
+package neuralnetwork
+
+import (
+	"fmt"
+
+	"github.com/timothy102/neuralnetwork/tensor"
+)
+
+//FlattenLayer layer
+type FlattenLayer struct {
+	BaseLayer
+	inputShape []int
+}
+
+//Flatten init. The layer flattens every sample while keeping the batch axis.
+func Flatten() *FlattenLayer {
+	return &FlattenLayer{BaseLayer: NewBaseLayer("flatten")}
+}
+
+//Build of the FlattenLayer
+func (f *FlattenLayer) Build(inputShape []int) ([]int, error) {
+	n := 1
+	for _, s := range inputShape {
+		n *= s
+	}
+	return []int{n}, nil
+}
+
+//Forward of the FlattenLayer reshapes [batch, ...] into [batch, features].
+func (f *FlattenLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape := inputs.Shape()
+	if len(shape) == 0 {
+		return nil, fmt.Errorf("%s: expected a batch of samples, got a scalar", f.name)
+	}
+	f.inputShape = shape
+	return inputs.Reshape(shape[0], -1)
+}
+
+//Backward of the FlattenLayer restores the input shape.
+func (f *FlattenLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if f.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", f.name)
+	}
+	return gradOutput.Reshape(f.inputShape...)
+}
+
+//ReshapeLayer gives every sample a new shape with the same number of values.
+type ReshapeLayer struct {
+	BaseLayer
+	targetShape []int
+	inputShape  []int
+}
+
+//Reshape returns a layer reshaping every sample to targetShape, batch axis excluded. One dimension may be -1, in
+//which case it is inferred from the size of the samples.
+func Reshape(targetShape ...int) *ReshapeLayer {
+	return &ReshapeLayer{BaseLayer: NewBaseLayer("reshape"), targetShape: append([]int(nil), targetShape...)}
+}
+
+//Build resolves the inferred dimension and returns the target shape.
+func (r *ReshapeLayer) Build(inputShape []int) ([]int, error) {
+	size, known, infer := 1, 1, -1
+	for _, s := range inputShape {
+		size *= s
+	}
+	out := append([]int(nil), r.targetShape...)
+	for i, s := range out {
+		switch {
+		case s == -1 && infer == -1:
+			infer = i
+		case s <= 0:
+			return nil, fmt.Errorf("%s: invalid target shape %v", r.name, r.targetShape)
+		default:
+			known *= s
+		}
+	}
+	if infer >= 0 && known > 0 && size%known == 0 {
+		out[infer] = size / known
+		known = size
+	}
+	if known != size {
+		return nil, fmt.Errorf("%s: cannot reshape samples of shape %v into %v", r.name, inputShape, r.targetShape)
+	}
+	return out, nil
+}
+
+//Forward reshapes [batch, ...] into [batch, targetShape...].
+func (r *ReshapeLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.name, err)
+	}
+	outShape, err := r.Build(shape)
+	if err != nil {
+		return nil, err
+	}
+	r.inputShape = inputs.Shape()
+	return inputs.Reshape(withBatch(r.inputShape[0], outShape)...)
+}
+
+//Backward restores the input shape.
+func (r *ReshapeLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if r.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", r.name)
+	}
+	return gradOutput.Reshape(r.inputShape...)
+}
+
+//PermuteLayer reorders the axes of every sample, for instance to swap the time and feature axes of a sequence.
+type PermuteLayer struct {
+	BaseLayer
+	axes       []int
+	inputShape []int
+}
+
+//Permute returns a layer moving input axis axes[i] to position i+1. Axes are numbered from 1 since the batch axis 0
+//never moves: Permute(2, 1) turns [batch, time, features] into [batch, features, time].
+func Permute(axes ...int) *PermuteLayer {
+	return &PermuteLayer{BaseLayer: NewBaseLayer("permute"), axes: append([]int(nil), axes...)}
+}
+
+//Build validates the permutation and returns the permuted shape.
+func (p *PermuteLayer) Build(inputShape []int) ([]int, error) {
+	if len(p.axes) != len(inputShape) {
+		return nil, fmt.Errorf("%s: permutation %v does not match samples of shape %v", p.name, p.axes, inputShape)
+	}
+	seen := make([]bool, len(p.axes))
+	out := make([]int, len(p.axes))
+	for i, ax := range p.axes {
+		if ax < 1 || ax > len(p.axes) || seen[ax-1] {
+			return nil, fmt.Errorf("%s: %v is not a permutation of the axes 1 to %d", p.name, p.axes, len(p.axes))
+		}
+		seen[ax-1] = true
+		out[i] = inputShape[ax-1]
+	}
+	return out, nil
+}
+
+//Forward permutes the axes of the inputs.
+func (p *PermuteLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", p.name, err)
+	}
+	if _, err := p.Build(shape); err != nil {
+		return nil, err
+	}
+	out, err := inputs.Transpose(append([]int{0}, p.axes...)...)
+	if err != nil {
+		return nil, err
+	}
+	p.inputShape = inputs.Shape()
+	return out.Contiguous(), nil
+}
+
+//Backward applies the inverse permutation to the gradient.
+func (p *PermuteLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if p.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", p.name)
+	}
+	inverse := make([]int, len(p.axes)+1)
+	for i, ax := range p.axes {
+		inverse[ax] = i + 1
+	}
+	grad, err := gradOutput.Transpose(inverse...)
+	if err != nil {
+		return nil, err
+	}
+	return grad.Contiguous(), nil
+}
+
+//RepeatVectorLayer repeats every [features] sample n times into [n, features], for instance to feed the encoding of a
+//sequence to every step of a recurrent decoder.
+type RepeatVectorLayer struct {
+	BaseLayer
+	n          int
+	inputShape []int
+}
+
+//RepeatVector returns a layer repeating its inputs n times.
+func RepeatVector(n int) *RepeatVectorLayer {
+	return &RepeatVectorLayer{BaseLayer: NewBaseLayer("repeat_vector"), n: n}
+}
+
+//Build validates the input shape and returns [n, features].
+func (r *RepeatVectorLayer) Build(inputShape []int) ([]int, error) {
+	if len(inputShape) != 1 {
+		return nil, fmt.Errorf("%s: expected inputs of shape [batch, features], got samples of shape %v", r.name, inputShape)
+	}
+	if r.n < 1 {
+		return nil, fmt.Errorf("%s: the number of repetitions must be positive, got %d", r.name, r.n)
+	}
+	return []int{r.n, inputShape[0]}, nil
+}
+
+//Forward repeats every sample.
+func (r *RepeatVectorLayer) Forward(inputs *tensor.Tensor, training bool) (*tensor.Tensor, error) {
+	shape, err := sampleShape(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", r.name, err)
+	}
+	if _, err := r.Build(shape); err != nil {
+		return nil, err
+	}
+	batch, features := inputs.Shape()[0], shape[0]
+	x := inputs.Data()
+	out := make([]float64, batch*r.n*features)
+	for b := 0; b < batch; b++ {
+		for i := 0; i < r.n; i++ {
+			copy(out[(b*r.n+i)*features:(b*r.n+i+1)*features], x[b*features:(b+1)*features])
+		}
+	}
+	r.inputShape = inputs.Shape()
+	return tensor.FromSlice(out, []int{batch, r.n, features})
+}
+
+//Backward sums the gradients of the repetitions.
+func (r *RepeatVectorLayer) Backward(gradOutput *tensor.Tensor) (*tensor.Tensor, error) {
+	if r.inputShape == nil {
+		return nil, fmt.Errorf("%s: Backward called before Forward", r.name)
+	}
+	if !equalShapes(gradOutput.Shape(), []int{r.inputShape[0], r.n, r.inputShape[1]}) {
+		return nil, fmt.Errorf("%s: gradient of shape %v does not match the outputs", r.name, gradOutput.Shape())
+	}
+	return gradOutput.SumAxis(1)
+}
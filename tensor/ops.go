@@ -0,0 +1,141 @@
+package tensor
+
+import (
+	"fmt"
+	"math"
+)
+
+//unary applies f to every element and records df, the derivative expressed through the input x and the output y.
+func (t *Tensor) unary(f func(x float64) float64, df func(x, y float64) float64) *Tensor {
+	out := t.Map(f)
+	return record(out, func(g *Tensor) []*Tensor {
+		in, res, gd := t.Data(), out.data, g.Data()
+		grad := Zeros(t.shape)
+		for i := range grad.data {
+			grad.data[i] = gd[i] * df(in[i], res[i])
+		}
+		return []*Tensor{grad}
+	}, t)
+}
+
+//Scale returns t multiplied by the scalar c.
+func (t *Tensor) Scale(c float64) *Tensor {
+	return t.unary(func(x float64) float64 { return c * x }, func(x, y float64) float64 { return c })
+}
+
+//Square returns the element-wise square.
+func (t *Tensor) Square() *Tensor {
+	return t.unary(func(x float64) float64 { return x * x }, func(x, y float64) float64 { return 2 * x })
+}
+
+//Sqrt returns the element-wise square root.
+func (t *Tensor) Sqrt() *Tensor {
+	return t.unary(math.Sqrt, func(x, y float64) float64 { return 0.5 / y })
+}
+
+//Exp returns the element-wise exponential.
+func (t *Tensor) Exp() *Tensor {
+	return t.unary(math.Exp, func(x, y float64) float64 { return y })
+}
+
+//Log returns the element-wise natural logarithm.
+func (t *Tensor) Log() *Tensor {
+	return t.unary(math.Log, func(x, y float64) float64 { return 1 / x })
+}
+
+//Tanh returns the element-wise hyperbolic tangent.
+func (t *Tensor) Tanh() *Tensor {
+	return t.unary(math.Tanh, func(x, y float64) float64 { return 1 - y*y })
+}
+
+//Sigmoid returns the element-wise logistic function.
+func (t *Tensor) Sigmoid() *Tensor {
+	return t.unary(sigmoid, func(x, y float64) float64 { return y * (1 - y) })
+}
+
+//Relu returns the element-wise rectified linear unit.
+func (t *Tensor) Relu() *Tensor {
+	return t.unary(func(x float64) float64 { return math.Max(x, 0) }, func(x, y float64) float64 {
+		if x > 0 {
+			return 1
+		}
+		return 0
+	})
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+//ReduceSum returns the sum of all elements as a scalar tensor.
+func (t *Tensor) ReduceSum() *Tensor {
+	out := Scalar(t.Sum())
+	return record(out, func(g *Tensor) []*Tensor {
+		return []*Tensor{Full(t.shape, g.data[g.offset])}
+	}, t)
+}
+
+//ReduceMean returns the mean of all elements as a scalar tensor.
+func (t *Tensor) ReduceMean() *Tensor {
+	return t.ReduceSum().Scale(1 / float64(t.Size()))
+}
+
+//SumAxis sums over axis and removes it from the shape.
+func (t *Tensor) SumAxis(axis int) (*Tensor, error) {
+	if t.OutOfAxis(axis) {
+		return nil, fmt.Errorf("Axis %d is not present in tensor shape %v. Validate your tensors with Shape", axis, t.shape)
+	}
+	keep := copyInts(t.shape)
+	keep[axis] = 1
+	summed := t.sumTo(keep)
+	shape := append(copyInts(t.shape[:axis]), t.shape[axis+1:]...)
+	out := &Tensor{data: summed.Data(), shape: shape, strides: contiguousStrides(shape)}
+	return record(out, func(g *Tensor) []*Tensor {
+		r, _ := g.Reshape(keep...)
+		b, _ := r.broadcastTo(t.shape)
+		return []*Tensor{b.Clone()}
+	}, t), nil
+}
+
+//MeanAxis averages over axis and removes it from the shape.
+func (t *Tensor) MeanAxis(axis int) (*Tensor, error) {
+	out, err := t.SumAxis(axis)
+	if err != nil {
+		return nil, err
+	}
+	return out.Scale(1 / float64(t.shape[axis])), nil
+}
+
+//MatMul returns the matrix product of two rank 2 tensors.
+func (t *Tensor) MatMul(t2 *Tensor) (*Tensor, error) {
+	if len(t.shape) != 2 || len(t2.shape) != 2 {
+		return nil, fmt.Errorf("Value Error: MatMul needs two matrices, got shapes %v and %v", t.shape, t2.shape)
+	}
+	if t.shape[1] != t2.shape[0] {
+		return nil, fmt.Errorf("Assertion Error: cannot multiply matrices of shapes %v and %v: axis 1 has size %d, want %d", t.shape, t2.shape, t.shape[1], t2.shape[0])
+	}
+	out := matmul(t, t2)
+	return record(out, func(g *Tensor) []*Tensor {
+		tt, _ := t.Transpose()
+		t2t, _ := t2.Transpose()
+		return []*Tensor{matmul(g, t2t), matmul(tt, g)}
+	}, t, t2), nil
+}
+
+//matmul multiplies an m x k by a k x n matrix without any checks.
+func matmul(a, b *Tensor) *Tensor {
+	m, k, n := a.shape[0], a.shape[1], b.shape[1]
+	ad, bd := a.Data(), b.Data()
+	out := Zeros([]int{m, n})
+	for i := 0; i < m; i++ {
+		row := out.data[i*n : (i+1)*n]
+		for p := 0; p < k; p++ {
+			av := ad[i*k+p]
+			brow := bd[p*n : (p+1)*n]
+			for j := range row {
+				row[j] += av * brow[j]
+			}
+		}
+	}
+	return out
+}
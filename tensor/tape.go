@@ -0,0 +1,117 @@
+package tensor
+
+import "fmt"
+
+//GradientTape records the operations applied to watched tensors and computes gradients of a scalar
+//with respect to any of them in one reverse-mode pass, much like tf.GradientTape.
+//
+//	tape := tensor.NewGradientTape()
+//	tape.Watch(w, b)
+//	y, _ := x.MatMul(w)
+//	y, _ = y.Add(b)
+//	loss := y.Square().ReduceMean()
+//	grads, err := tape.Gradient(loss, w, b)
+type GradientTape struct {
+	nodes     []*node
+	recording bool
+}
+
+//node is one recorded operation. backward maps the gradient of output to the gradients of inputs.
+type node struct {
+	output   *Tensor
+	inputs   []*Tensor
+	backward func(grad *Tensor) []*Tensor
+}
+
+//NewGradientTape returns a tape that is recording.
+func NewGradientTape() *GradientTape {
+	return &GradientTape{recording: true}
+}
+
+//Watch marks the tensors as requiring gradients. Every later operation that consumes them is recorded on the tape.
+func (gt *GradientTape) Watch(ts ...*Tensor) {
+	for _, t := range ts {
+		t.tape = gt
+		t.requiresGrad = true
+	}
+}
+
+//Stop stops recording. Operations executed afterwards are not differentiated.
+func (gt *GradientTape) Stop() {
+	gt.recording = false
+}
+
+//Resume resumes recording after Stop.
+func (gt *GradientTape) Resume() {
+	gt.recording = true
+}
+
+//Reset drops every recorded operation so the tape can be reused for the next step.
+func (gt *GradientTape) Reset() {
+	gt.nodes = nil
+}
+
+//RequiresGrad reports whether the tensor is watched by a tape or was computed from a watched tensor.
+func (t *Tensor) RequiresGrad() bool {
+	return t.requiresGrad
+}
+
+//Detach returns a view of t that shares its data but is not connected to any tape.
+func (t *Tensor) Detach() *Tensor {
+	return &Tensor{data: t.data, shape: copyInts(t.shape), strides: copyInts(t.strides), offset: t.offset, name: t.name}
+}
+
+//record registers out as the result of an operation on inputs if one of them is being recorded.
+func record(out *Tensor, backward func(grad *Tensor) []*Tensor, inputs ...*Tensor) *Tensor {
+	for _, in := range inputs {
+		if in.requiresGrad && in.tape != nil && in.tape.recording {
+			out.tape = in.tape
+			out.requiresGrad = true
+			in.tape.nodes = append(in.tape.nodes, &node{output: out, inputs: inputs, backward: backward})
+			return out
+		}
+	}
+	return out
+}
+
+//Gradient computes the gradients of target with respect to sources. Target must hold a single element.
+//A source that target does not depend on gets a gradient of zeros.
+func (gt *GradientTape) Gradient(target *Tensor, sources ...*Tensor) ([]*Tensor, error) {
+	if target.Size() != 1 {
+		return nil, fmt.Errorf("Value Error: gradient target must be a scalar, got shape %v", target.shape)
+	}
+	if target.tape != gt && len(sources) > 0 {
+		return nil, fmt.Errorf("Value Error: target %q was not recorded on this tape", target.name)
+	}
+	recording := gt.recording
+	gt.recording = false
+	defer func() { gt.recording = recording }()
+
+	grads := map[*Tensor]*Tensor{target: Ones(target.shape)}
+	for i := len(gt.nodes) - 1; i >= 0; i-- {
+		n := gt.nodes[i]
+		g, ok := grads[n.output]
+		if !ok {
+			continue
+		}
+		for j, ig := range n.backward(g) {
+			in := n.inputs[j]
+			if ig == nil || !in.requiresGrad {
+				continue
+			}
+			if prev, ok := grads[in]; ok {
+				ig, _ = prev.Add(ig)
+			}
+			grads[in] = ig
+		}
+	}
+	out := make([]*Tensor, len(sources))
+	for i, s := range sources {
+		if g, ok := grads[s]; ok {
+			out[i] = g.Contiguous()
+		} else {
+			out[i] = Zeros(s.shape)
+		}
+	}
+	return out, nil
+}
@@ -1,419 +1,769 @@
+//Package tensor implements a dense n-dimensional array of float64 values.
 package tensor
 
 import (
 	"fmt"
 	"math"
 	"reflect"
+	"strings"
 )
 
+//Tensor holds its values in a flat float64 buffer together with the shape and the strides
+//needed to address it. Views such as Reshape, Transpose or Index share the buffer with the original tensor.
 type Tensor struct {
-	number interface{}
-	value  reflect.Value
-	dtype  reflect.Type
-	shape  []int
-	name   string
-	errors RaiseError{}
+	data    []float64
+	shape   []int
+	strides []int
+	offset  int
+	name    string
+
+	tape         *GradientTape
+	requiresGrad bool
 }
 
-func NewTensor(a interface{}) *Tensor {
+//NewTensor converts a scalar or an arbitrarily nested slice or array of numbers into a tensor.
+//Every sub-slice on the same level must have the same length.
+func NewTensor(a interface{}) (*Tensor, error) {
+	if a == nil {
+		return nil, fmt.Errorf("You have passed an empty interface to be transformed. ")
+	}
 	val := reflect.ValueOf(a)
 	var shape []int
-	typ := val.Type()
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
+	for v := val; v.Kind() == reflect.Array || v.Kind() == reflect.Slice; {
+		shape = append(shape, v.Len())
+		if v.Len() == 0 {
+			break
 		}
-		val = typ.Elem()
+		v = v.Index(0)
+	}
+	data := make([]float64, 0, numElements(shape))
+	data, err := flatten(val, shape, data)
+	if err != nil {
+		return nil, err
 	}
+	return &Tensor{data: data, shape: shape, strides: contiguousStrides(shape)}, nil
+}
 
-	return &Tensor{value: val, number: a, shape: shape}
+func flatten(v reflect.Value, shape []int, data []float64) ([]float64, error) {
+	if len(shape) == 0 {
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return append(data, v.Float()), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return append(data, float64(v.Int())), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return append(data, float64(v.Uint())), nil
+		case reflect.Interface:
+			return flatten(v.Elem(), shape, data)
+		}
+		return nil, ValueError(v)
+	}
+	if v.Kind() != reflect.Array && v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("Value Error: expected a slice of length %d, got %v", shape[0], v.Type())
+	}
+	if v.Len() != shape[0] {
+		return nil, fmt.Errorf("Value Error: ragged nested slice, expected length %d but got %d", shape[0], v.Len())
+	}
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if data, err = flatten(v.Index(i), shape[1:], data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
 }
 
-func (t *Tensor) Shape() []int {
-	return t.shape
+//FromSlice returns a tensor of the given shape backed by data. The slice is not copied.
+func FromSlice(data []float64, shape []int) (*Tensor, error) {
+	if numElements(shape) != len(data) {
+		return nil, fmt.Errorf("Value Error: cannot shape %d values into %v", len(data), shape)
+	}
+	return &Tensor{data: data, shape: copyInts(shape), strides: contiguousStrides(shape)}, nil
 }
 
-func (t *Tensor) Name() []int {
-	return t.name
+//Zeros returns a tensor of the given shape filled with zeros.
+func Zeros(shape []int) *Tensor {
+	return &Tensor{data: make([]float64, numElements(shape)), shape: copyInts(shape), strides: contiguousStrides(shape)}
 }
 
-func (t *Tensor) Dtype() reflect.Type {
-	return t.dtype
+//Full returns a tensor of the given shape filled with value.
+func Full(shape []int, value float64) *Tensor {
+	t := Zeros(shape)
+	for i := range t.data {
+		t.data[i] = value
+	}
+	return t
 }
 
-func (t *Tensor) ToValue() *reflect.Value {
-	return &reflect.Value(t.number)
+//Ones returns a tensor of the given shape filled with ones.
+func Ones(shape []int) *Tensor {
+	return Full(shape, 1)
 }
+
+//Scalar returns a rank 0 tensor holding value.
+func Scalar(value float64) *Tensor {
+	return &Tensor{data: []float64{value}}
+}
+
+//Placeholder returns a zero valued tensor of the given shape.
 func Placeholder(shape []int) *Tensor {
-	return &Tensor{shape: shape}
+	return Zeros(shape)
 }
 
-func TensorFromValue(v reflect.Value) (*Tensor, error){
-	if v == nil{
-		return nil, fmt.Errorf("You have passed an empty interface (%T)to be transformed. ", v.Addr().Type())
+//TensorFromValue converts a reflected scalar, slice or array into a tensor.
+func TensorFromValue(v reflect.Value) (*Tensor, error) {
+	if !v.IsValid() {
+		return nil, fmt.Errorf("You have passed an invalid reflect.Value to be transformed. ")
 	}
-	return &Tensor(value: v, dtype: v.Elem().Type())
+	return NewTensor(v.Interface())
 }
 
-func AssertionError(x, y *Tensor) error {
-	valX, valY := reflect.Value(x.value).Type(), reflect.Value(y.value).Type()
-	if valX != valY {
-		return fmt.Errorf("Assertion Error: given tensors do not have the same types: %T ----- %T\n", valX, valY)
+func numElements(shape []int) int {
+	n := 1
+	for _, s := range shape {
+		n *= s
 	}
-	for i := range x.shape {
-		if x.shape[i] != y.shape[i] {
-			return fmt.Errorf("Assertion Error: given tensors do not have the same shapes")
-			break
-		}
+	return n
+}
 
+func contiguousStrides(shape []int) []int {
+	strides := make([]int, len(shape))
+	stride := 1
+	for i := len(shape) - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= shape[i]
 	}
-	return nil
+	return strides
+}
+
+func copyInts(a []int) []int {
+	return append([]int(nil), a...)
+}
+
+func equalShapes(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
+
+//Shape returns a copy of the tensor's shape.
+func (t *Tensor) Shape() []int {
+	return copyInts(t.shape)
+}
+
+//Strides returns a copy of the number of buffer positions skipped when moving along each axis.
+func (t *Tensor) Strides() []int {
+	return copyInts(t.strides)
+}
+
+//Rank returns the number of axes.
+func (t *Tensor) Rank() int {
+	return len(t.shape)
+}
+
+//Size returns the number of elements.
+func (t *Tensor) Size() int {
+	return numElements(t.shape)
+}
+
+//Name returns the tensor's name.
+func (t *Tensor) Name() string {
+	return t.name
+}
+
+//SetName sets the tensor's name.
 func (t *Tensor) SetName(name string) {
 	t.name = name
 }
-func printType(a interface{}) {
-	fmt.Printf("a: %v\n", a)
+
+//Dtype returns the type of the stored elements, which is always float64.
+func (t *Tensor) Dtype() reflect.Type {
+	return reflect.TypeOf(float64(0))
 }
 
-func (t *Tensor) TensorArray() interface{} {
-	typ, arr := t.value, make([]float64, 1)
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
+//IsContiguous reports whether the elements are laid out in row-major order without gaps.
+func (t *Tensor) IsContiguous() bool {
+	stride := 1
+	for i := len(t.shape) - 1; i >= 0; i-- {
+		if t.shape[i] != 1 && t.strides[i] != stride {
+			return false
 		}
-		val = typ.Elem()
-		arr = append(arr, val)
+		stride *= t.shape[i]
 	}
-	return arr
+	return true
 }
 
-func (*t.Tensor) AtShape(shape []int, a int) interface{} {
-	num := t.number
-	for _, k := range shape {
-		num := num[k]
+//Data returns the elements in row-major order. For contiguous tensors the returned slice shares memory with the tensor.
+func (t *Tensor) Data() []float64 {
+	if t.IsContiguous() {
+		return t.data[t.offset : t.offset+t.Size()]
 	}
-	return num
+	return t.Contiguous().data
 }
 
-func (t *Tensor) Add(t2 *Tensor) (*Tensor, error) {
-	// CHECK MATCHING SHAPES
-	if err := AssertionError(t, t2); err != nil {
-		return nil, err
-	}
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
-		}
-		val = typ.Elem()
-		count += 1
-		t.number += t2.AtShape(shape, count)
+//Contiguous returns t if it is already contiguous and a row-major copy otherwise.
+func (t *Tensor) Contiguous() *Tensor {
+	if t.IsContiguous() {
+		return t
 	}
-	return t, nil
+	return t.Clone()
 }
 
-func (t *Tensor) Substract(t2 *Tensor) (*Tensor, error) {
-	// CHECK MATCHING SHAPES
-	if err := AssertionError(t, t2); err != nil {
-		return nil, err
-	}
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
+//Clone returns a contiguous deep copy of the tensor.
+func (t *Tensor) Clone() *Tensor {
+	out := Zeros(t.shape)
+	i := 0
+	t.forEach(func(pos int) {
+		out.data[i] = t.data[pos]
+		i++
+	})
+	out.name = t.name
+	return record(out, func(g *Tensor) []*Tensor { return []*Tensor{g} }, t)
+}
+
+//forEach calls fn with the buffer position of every element in row-major order.
+func (t *Tensor) forEach(fn func(pos int)) {
+	size := t.Size()
+	if t.IsContiguous() {
+		for i := 0; i < size; i++ {
+			fn(t.offset + i)
+		}
+		return
+	}
+	idx := make([]int, len(t.shape))
+	pos := t.offset
+	for n := 0; n < size; n++ {
+		fn(pos)
+		for ax := len(t.shape) - 1; ax >= 0; ax-- {
+			idx[ax]++
+			pos += t.strides[ax]
+			if idx[ax] < t.shape[ax] {
+				break
+			}
+			pos -= idx[ax] * t.strides[ax]
+			idx[ax] = 0
 		}
-		val = typ.Elem()
-		count += 1
-		t.number -= t2.AtShape(shape, count)
 	}
-	return t, nil
 }
 
-func (t *Tensor) Multiply(t2 *Tensor) (*Tensor, error) {
-	// CHECK MATCHING SHAPES
-	if err := AssertionError(t, t2); err != nil {
-		return nil, err
+func (t *Tensor) position(idx []int) (int, error) {
+	if len(idx) != len(t.shape) {
+		return 0, fmt.Errorf("Index Error: got %d indices for a tensor of rank %d", len(idx), len(t.shape))
 	}
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
+	pos := t.offset
+	for i, k := range idx {
+		if k < 0 || k >= t.shape[i] {
+			return 0, fmt.Errorf("Index Error: index %d is out of range for axis %d with size %d", k, i, t.shape[i])
 		}
-		val = typ.Elem()
-		count += 1
-		t.number *= t2.AtShape(shape, count)
+		pos += k * t.strides[i]
 	}
-	return t, nil
+	return pos, nil
 }
 
-func isZero(x float64) bool {
-	return x == 0
+//At returns the element at the given index. It panics if the index is out of range.
+func (t *Tensor) At(idx ...int) float64 {
+	pos, err := t.position(idx)
+	if err != nil {
+		panic(err)
+	}
+	return t.data[pos]
 }
 
-func isZeroCounts(x float64, count int) int {
-	count += x == 0
-}
-func DivisionByZero() error {
-	return fmt.Errorf("Division by Zero present. Inspect your tensors via the Shape, Inspect or Placeholder function. ")
+//Set stores value at the given index. It panics if the index is out of range.
+func (t *Tensor) Set(value float64, idx ...int) {
+	pos, err := t.position(idx)
+	if err != nil {
+		panic(err)
+	}
+	t.data[pos] = value
 }
 
-func (t *Tensor) Divide(t2 *Tensor) (*Tensor, error) {
-	// CHECK MATCHING SHAPES
-	if err := AssertionError(t, t2); err != nil {
-		return nil, err
-	}
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
-		}
-		val = typ.Elem()
-		count += 1
-		elem := t2.AtShape(shape, count)
-		if isZero(elem) {
-			return nil, DivisionByZero()
-		}
-		t.number /= t2.AtShape(shape, count)
+//Item returns the value of a tensor holding a single element.
+func (t *Tensor) Item() (float64, error) {
+	if t.Size() != 1 {
+		return 0, fmt.Errorf("Value Error: Item needs a tensor with one element, got shape %v", t.shape)
 	}
-	return t, nil
+	return t.data[t.offset], nil
 }
 
-func (t *Tensor) Map(f func(interface{}) interface{}) *Tensor {
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
-		}
-		val = typ.Elem()
-		val = f(val)
+//ToNested converts the tensor back into nested float64 slices, e.g. [][]float64 for a matrix.
+//A rank 0 tensor is returned as a float64.
+func (t *Tensor) ToNested() interface{} {
+	typ := t.Dtype()
+	for range t.shape {
+		typ = reflect.SliceOf(typ)
 	}
-	return t.Placeholder(t.value)
+	data := t.Data()
+	return buildNested(typ, t.shape, data).Interface()
 }
 
-// a and b are indices for shape
-func (t *Tensor) Gather(a, b int) {
+func buildNested(typ reflect.Type, shape []int, data []float64) reflect.Value {
+	if len(shape) == 0 {
+		return reflect.ValueOf(data[0])
+	}
+	if len(shape) == 1 {
+		return reflect.ValueOf(append([]float64(nil), data...))
+	}
+	out := reflect.MakeSlice(typ, shape[0], shape[0])
+	step := numElements(shape[1:])
+	for i := 0; i < shape[0]; i++ {
+		out.Index(i).Set(buildNested(typ.Elem(), shape[1:], data[i*step:(i+1)*step]))
+	}
+	return out
+}
 
+//ToValue returns the reflected nested representation of the tensor.
+func (t *Tensor) ToValue() reflect.Value {
+	return reflect.ValueOf(t.ToNested())
 }
 
-// follow the tf documentation and implement
-func (t *Tensor) ZeroCounts() int {
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
-		}
-		val = typ.Elem()
-		count += isZero(val)
+//String formats the tensor like its nested slice representation.
+func (t *Tensor) String() string {
+	var b strings.Builder
+	if t.name != "" {
+		b.WriteString(t.name + ": ")
 	}
-	return count
+	fmt.Fprintf(&b, "%v %v", t.ToNested(), t.shape)
+	return b.String()
 }
 
-func (t* Tensor) GetAxis(axis int) (t*Tensor, error){
-	val := t.value
-
-	if t.OutOfAxis(axis) {
-		return nil, fmt.Errorf("Axis %d is not present in tensor shape. ", axis, "Validate your tensors with TensorSpec or Shape \n")
+//AssertionError returns an error if x and y do not have the same shape.
+func AssertionError(x, y *Tensor) error {
+	if !equalShapes(x.shape, y.shape) {
+		return fmt.Errorf("Assertion Error: given tensors do not have the same shapes: %v ----- %v", x.shape, y.shape)
 	}
-	for i:=0;i<axis;i++{
-		val = val[i]
+	return nil
+}
+
+//ValueError returns the error reported for values that cannot be stored in a tensor.
+func ValueError(value reflect.Value) error {
+	if !value.IsValid() {
+		return fmt.Errorf("Value Error: invalid value does not match Tensor interface")
 	}
-	return TensorFromValue(val)
+	return fmt.Errorf("Value Error: %v does not match Tensor interface", value.Type())
 }
 
-func (t *Tensor) OutOfAxis(axis int) bool {
-	return axis > len(t.shape)
+//DivisionByZero returns the error reported when dividing by a tensor containing zeros.
+func DivisionByZero() error {
+	return fmt.Errorf("Division by Zero present. Inspect your tensors via the Shape, Inspect or Placeholder function. ")
 }
 
-func switch(a,b int) int,int{
-	return b,a
+//OutOfAxis reports whether axis is not an axis of t.
+func (t *Tensor) OutOfAxis(axis int) bool {
+	return axis < 0 || axis >= len(t.shape)
 }
 
-func (t *Tensor) Gather(a, b, axis int) (*Tensor, error) {
-	if t.OutOfAxis(axis) {
-		return nil, fmt.Errorf("Axis %d is not present in tensor shape. ", axis, "Validate your tensors with TensorSpec or Shape \n")
+//BroadcastShapes returns the shape produced by broadcasting a against b following the NumPy rules:
+//shapes are aligned at their trailing axes and an axis of size 1 is stretched to match the other one.
+func BroadcastShapes(a, b []int) ([]int, error) {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	out := make([]int, n)
+	for i := 1; i <= n; i++ {
+		da, db := 1, 1
+		if i <= len(a) {
+			da = a[len(a)-i]
+		}
+		if i <= len(b) {
+			db = b[len(b)-i]
+		}
+		switch {
+		case da == db || db == 1:
+			out[n-i] = da
+		case da == 1:
+			out[n-i] = db
+		default:
+			return nil, fmt.Errorf("Assertion Error: shapes %v and %v cannot be broadcast together: axis %d has sizes %d and %d", a, b, n-i, da, db)
+		}
 	}
-	k, err := t.GetAxis(axis)
-	if err !=nil{
+	return out, nil
+}
+
+//BroadcastTo returns a view of t stretched to shape. No data is copied; stretched axes get a stride of 0.
+func (t *Tensor) BroadcastTo(shape []int) (*Tensor, error) {
+	out, err := t.broadcastTo(shape)
+	if err != nil {
 		return nil, err
 	}
-	if b>a{
-		return nil, fmt.Errorf("In the interval %d to %d you have passed the larger number first. You can also deal with this by calling the switch function\n. ",a,b)
+	return record(out, func(g *Tensor) []*Tensor { return []*Tensor{g.sumTo(t.shape)} }, t), nil
+}
+
+func (t *Tensor) broadcastTo(shape []int) (*Tensor, error) {
+	if len(shape) < len(t.shape) {
+		return nil, fmt.Errorf("Assertion Error: cannot broadcast shape %v to the lower rank shape %v", t.shape, shape)
 	}
-	return k[a:b]
+	out := &Tensor{data: t.data, offset: t.offset, shape: copyInts(shape), strides: make([]int, len(shape)), name: t.name}
+	lead := len(shape) - len(t.shape)
+	for i, s := range t.shape {
+		switch {
+		case s == shape[lead+i]:
+			out.strides[lead+i] = t.strides[i]
+		case s == 1:
+			out.strides[lead+i] = 0
+		default:
+			return nil, fmt.Errorf("Assertion Error: cannot broadcast shape %v to %v: axis %d has size %d, want %d or 1", t.shape, shape, lead+i, s, shape[lead+i])
+		}
+	}
+	return out, nil
+}
 
+//sumTo sums a broadcast gradient back down to shape.
+func (t *Tensor) sumTo(shape []int) *Tensor {
+	if equalShapes(t.shape, shape) {
+		return t
+	}
+	out := Zeros(shape)
+	view, err := out.broadcastTo(t.shape)
+	if err != nil {
+		panic(err)
+	}
+	data, i := t.Data(), 0
+	view.forEach(func(pos int) {
+		out.data[pos] += data[i]
+		i++
+	})
+	return out
 }
 
-func ValueError(value reflect.Value) error{
-	ty := value.Type()
-	if ty == nil && ty == string{
-		return fmt.Errorf("%T does not match Tensor interface", ty)
+func (t *Tensor) zipWith(t2 *Tensor, f func(a, b float64) float64) (*Tensor, error) {
+	shape, err := BroadcastShapes(t.shape, t2.shape)
+	if err != nil {
+		return nil, err
 	}
-	tensor := TensorFromValue(value)
-	if len(tensor.shape) > 1e6{
-		return fmt.Errorf("You have entered an interface size greater than the RAM size: %d", len(tensor.shape))
+	x, err := t.broadcastTo(shape)
+	if err != nil {
+		return nil, err
 	}
-	
-	if _,err:=tensor.GetAxis(0);err!=nil{
-		return fmt.Errorf("The given tensor is not valid due to no axis found. Treat carefully.")
+	y, err := t2.broadcastTo(shape)
+	if err != nil {
+		return nil, err
+	}
+	out := Zeros(shape)
+	i := 0
+	x.forEach(func(pos int) {
+		out.data[i] = x.data[pos]
+		i++
+	})
+	i = 0
+	y.forEach(func(pos int) {
+		out.data[i] = f(out.data[i], y.data[pos])
+		i++
+	})
+	return out, nil
+}
+
+//Add returns the element-wise sum of t and t2, broadcasting their shapes against each other.
+func (t *Tensor) Add(t2 *Tensor) (*Tensor, error) {
+	out, err := t.zipWith(t2, func(a, b float64) float64 { return a + b })
+	if err != nil {
+		return nil, err
 	}
+	return record(out, func(g *Tensor) []*Tensor {
+		return []*Tensor{g.sumTo(t.shape), g.sumTo(t2.shape)}
+	}, t, t2), nil
+}
 
-	return nil
+//Substract returns the element-wise difference of t and t2, broadcasting their shapes against each other.
+func (t *Tensor) Substract(t2 *Tensor) (*Tensor, error) {
+	out, err := t.zipWith(t2, func(a, b float64) float64 { return a - b })
+	if err != nil {
+		return nil, err
+	}
+	return record(out, func(g *Tensor) []*Tensor {
+		return []*Tensor{g.sumTo(t.shape), g.Scale(-1).sumTo(t2.shape)}
+	}, t, t2), nil
 }
 
-// Squeze removes the dimensison with size 1
-func (t* Tensor) Squeeze(){
-	typ, count := t.value, 0
-	for typ.Kind() == reflect.Array || typ.Kind() == reflect.Slice {
-		shape = append(shape, val.Len())
-		if val.Len() > 0 {
-			val = val.Index(0)
-		}
-		if shape[0] == 1{
-			f := t.number[:][:]
-		}
+//Multiply returns the element-wise product of t and t2, broadcasting their shapes against each other.
+func (t *Tensor) Multiply(t2 *Tensor) (*Tensor, error) {
+	out, err := t.zipWith(t2, func(a, b float64) float64 { return a * b })
+	if err != nil {
+		return nil, err
 	}
+	return record(out, func(g *Tensor) []*Tensor {
+		ga, _ := g.zipWith(t2, func(a, b float64) float64 { return a * b })
+		gb, _ := g.zipWith(t, func(a, b float64) float64 { return a * b })
+		return []*Tensor{ga.sumTo(t.shape), gb.sumTo(t2.shape)}
+	}, t, t2), nil
 }
-func tape(f func(x float64) float64, x float64) float64 {
-	epsilon := 1e-6
-	deltax := f(x+epsilon) - f(x)
-	return deltax / epsilon
+
+//Divide returns the element-wise quotient of t and t2, broadcasting their shapes against each other. It fails if t2 contains a zero.
+func (t *Tensor) Divide(t2 *Tensor) (*Tensor, error) {
+	if t2.ZeroCounts() > 0 {
+		return nil, DivisionByZero()
+	}
+	out, err := t.zipWith(t2, func(a, b float64) float64 { return a / b })
+	if err != nil {
+		return nil, err
+	}
+	return record(out, func(g *Tensor) []*Tensor {
+		ga, _ := g.zipWith(t2, func(a, b float64) float64 { return a / b })
+		gb, _ := g.zipWith(out, func(a, b float64) float64 { return a * b })
+		gb, _ = gb.zipWith(t2, func(a, b float64) float64 { return -a / b })
+		return []*Tensor{ga.sumTo(t.shape), gb.sumTo(t2.shape)}
+	}, t, t2), nil
 }
 
-func sigmoid(x float64) float64 {
-	return 1 / (1 - math.Exp(-x))
+//Map returns a new tensor with f applied to every element. Map is not recorded on a GradientTape,
+//use the differentiable element-wise operations such as Exp, Tanh or Relu instead.
+func (t *Tensor) Map(f func(float64) float64) *Tensor {
+	out := Zeros(t.shape)
+	i := 0
+	t.forEach(func(pos int) {
+		out.data[i] = f(t.data[pos])
+		i++
+	})
+	return out
 }
 
-func sigmoidDerivative(x float64) float64 {
-	return sigmoid(x) * (1 - sigmoid(x))
+func isZero(x float64) bool {
+	return x == 0
 }
 
-type RaiseError interface {
-	Init()
-	Check() bool
-	Raise() error
+//ZeroCounts returns the number of elements equal to zero.
+func (t *Tensor) ZeroCounts() int {
+	count := 0
+	t.forEach(func(pos int) {
+		if isZero(t.data[pos]) {
+			count++
+		}
+	})
+	return count
 }
 
-type ValueError struct {
-	tensor *Tensor
-	raised bool
+//Sum returns the sum of all elements.
+func (t *Tensor) Sum() float64 {
+	var total float64
+	t.forEach(func(pos int) {
+		total += t.data[pos]
+	})
+	return total
 }
 
-func (ve *ValueError) Check(tensor Tensor) (bool, error) {
-	value := tensor.Value()
-	ty := value.Type()
-	if ty == nil && ty == string {
-		return true, fmt.Errorf("%T does not match Tensor interface", ty)
+//Max returns the largest element.
+func (t *Tensor) Max() float64 {
+	max := math.Inf(-1)
+	t.forEach(func(pos int) {
+		if t.data[pos] > max {
+			max = t.data[pos]
+		}
+	})
+	return max
+}
+
+//Reshape returns a tensor with the same elements and a new shape. One dimension may be -1, in which case it is inferred.
+//The result shares memory with t when t is contiguous.
+func (t *Tensor) Reshape(shape ...int) (*Tensor, error) {
+	shape = copyInts(shape)
+	infer, known := -1, 1
+	for i, s := range shape {
+		switch {
+		case s == -1 && infer == -1:
+			infer = i
+		case s < 0:
+			return nil, fmt.Errorf("Value Error: invalid shape %v", shape)
+		default:
+			known *= s
+		}
 	}
-	if len(tensor.shape) > 1e6 {
-		return true, fmt.Errorf("You have entered an interface size greater than the RAM size: %d", len(tensor.shape))
+	if infer >= 0 {
+		if known == 0 || t.Size()%known != 0 {
+			return nil, fmt.Errorf("Value Error: cannot reshape tensor of shape %v into %v", t.shape, shape)
+		}
+		shape[infer] = t.Size() / known
 	}
-
-	if _, err := tensor.GetAxis(0); err != nil {
-		return true, fmt.Errorf("The given tensor is not valid due to no axis found. Treat carefully.")
+	if numElements(shape) != t.Size() {
+		return nil, fmt.Errorf("Value Error: cannot reshape tensor of shape %v into %v", t.shape, shape)
 	}
-
-	return false, nil
+	c := t.Contiguous()
+	out := &Tensor{data: c.data, shape: shape, strides: contiguousStrides(shape), offset: c.offset, name: t.name}
+	return record(out, func(g *Tensor) []*Tensor {
+		r, _ := g.Reshape(t.shape...)
+		return []*Tensor{r}
+	}, c), nil
 }
 
-func (ve *ValueError) Raise() error {
-	raised, err := ve.Check(ve.tensor)
-	ve.raised = raised
-	if err != nil {
-		return fmt.Errorf("ValueError raised. ")
+//Transpose returns a view with the axes permuted. Without arguments the axes are reversed.
+func (t *Tensor) Transpose(axes ...int) (*Tensor, error) {
+	if len(axes) == 0 {
+		for i := len(t.shape) - 1; i >= 0; i-- {
+			axes = append(axes, i)
+		}
 	}
-	return nil
+	if len(axes) != len(t.shape) {
+		return nil, fmt.Errorf("Value Error: permutation %v does not match rank %d", axes, len(t.shape))
+	}
+	seen := make([]bool, len(axes))
+	out := &Tensor{data: t.data, offset: t.offset, shape: make([]int, len(axes)), strides: make([]int, len(axes))}
+	for i, ax := range axes {
+		if t.OutOfAxis(ax) || seen[ax] {
+			return nil, fmt.Errorf("Value Error: invalid permutation %v", axes)
+		}
+		seen[ax] = true
+		out.shape[i], out.strides[i] = t.shape[ax], t.strides[ax]
+	}
+	return record(out, func(g *Tensor) []*Tensor {
+		inverse := make([]int, len(axes))
+		for i, ax := range axes {
+			inverse[ax] = i
+		}
+		r, _ := g.Transpose(inverse...)
+		return []*Tensor{r}
+	}, t), nil
 }
 
-type AssertionError struct {
-	tensor1, tensor2 *Tensor
-	raised           bool
+//Index returns the sub-tensor t[i] as a view.
+func (t *Tensor) Index(i int) (*Tensor, error) {
+	out, err := t.index(i)
+	if err != nil {
+		return nil, err
+	}
+	return record(out, func(g *Tensor) []*Tensor {
+		full := Zeros(t.shape)
+		view, _ := full.index(i)
+		view.assign(g)
+		return []*Tensor{full}
+	}, t), nil
 }
 
-func (ae *AssertionError) Check(x, y *Tensor) (bool, error) {
-	valX, valY := reflect.Value(x.value).Type(), reflect.Value(y.value).Type()
-	if valX != valY {
-		return true, fmt.Errorf("Assertion Error: given tensors do not have the same types: %T ----- %T\n", valX, valY)
+func (t *Tensor) index(i int) (*Tensor, error) {
+	if len(t.shape) == 0 {
+		return nil, fmt.Errorf("Index Error: cannot index a scalar tensor")
 	}
-	for i := range x.shape {
-		if x.shape[i] != y.shape[i] {
-			return true, fmt.Errorf("Assertion Error: given tensors do not have the same shapes")
-			break
-		}
-
+	if i < 0 || i >= t.shape[0] {
+		return nil, fmt.Errorf("Index Error: index %d is out of range for axis 0 with size %d", i, t.shape[0])
 	}
-	return false, nil
+	return &Tensor{data: t.data, shape: copyInts(t.shape[1:]), strides: copyInts(t.strides[1:]), offset: t.offset + i*t.strides[0]}, nil
 }
 
-func (ae *AssertionError) Raise() error {
-	raised, err := ae.Check(ae.tensor1, ae.tensor2)
-	ae.raised = raised
+//Gather returns the elements with index a up to but not including b along axis as a view.
+func (t *Tensor) Gather(a, b, axis int) (*Tensor, error) {
+	out, err := t.gather(a, b, axis)
 	if err != nil {
-		return fmt.Errorf("Assertion error raised. ")
+		return nil, err
 	}
-	return nil
+	return record(out, func(g *Tensor) []*Tensor {
+		full := Zeros(t.shape)
+		view, _ := full.gather(a, b, axis)
+		view.assign(g)
+		return []*Tensor{full}
+	}, t), nil
 }
 
-type OutOfRangeError struct {
-	tensor *Tensor
-	a, b   int
+func (t *Tensor) gather(a, b, axis int) (*Tensor, error) {
+	if t.OutOfAxis(axis) {
+		return nil, fmt.Errorf("Axis %d is not present in tensor shape %v. Validate your tensors with Shape", axis, t.shape)
+	}
+	if a > b {
+		return nil, fmt.Errorf("In the interval %d to %d you have passed the larger number first", a, b)
+	}
+	if a < 0 || b > t.shape[axis] {
+		return nil, fmt.Errorf("Index Error: interval %d to %d is out of range for axis %d with size %d", a, b, axis, t.shape[axis])
+	}
+	out := &Tensor{data: t.data, shape: copyInts(t.shape), strides: copyInts(t.strides), offset: t.offset + a*t.strides[axis]}
+	out.shape[axis] = b - a
+	return out, nil
 }
 
-type UnknownError struct {
-	tensor *Tensor
+//Take returns a copy holding t[indices[0]], t[indices[1]], ... stacked along a new first axis.
+//The same index may appear several times.
+func (t *Tensor) Take(indices []int) (*Tensor, error) {
+	if len(t.shape) == 0 {
+		return nil, fmt.Errorf("Index Error: cannot index a scalar tensor")
+	}
+	shape := append([]int{len(indices)}, t.shape[1:]...)
+	out := Zeros(shape)
+	step := numElements(t.shape[1:])
+	for i, k := range indices {
+		row, err := t.index(k)
+		if err != nil {
+			return nil, err
+		}
+		dst, _ := out.index(i)
+		dst.assign(row)
+	}
+	return record(out, func(g *Tensor) []*Tensor {
+		full := Zeros(t.shape)
+		gd := g.Data()
+		for i, k := range indices {
+			dst := full.data[k*step : (k+1)*step]
+			for j, v := range gd[i*step : (i+1)*step] {
+				dst[j] += v
+			}
+		}
+		return []*Tensor{full}
+	}, t), nil
 }
 
-func (ue *UnknownError) Check(t *Tensor) (bool,error){
-	ue.tensor = t
-	if ue.tensor.Value().Type() == nil || ue.tensor.Value().Type() == string{
-		return true, fmt.Errorf("%T\n unknown and cannot be converted into Tensor", type(t))
+//Concat joins tensors along axis. All other axes must have the same sizes.
+func Concat(ts []*Tensor, axis int) (*Tensor, error) {
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("Value Error: nothing to concatenate")
+	}
+	first := ts[0]
+	if first.OutOfAxis(axis) {
+		return nil, fmt.Errorf("Axis %d is not present in tensor shape %v. Validate your tensors with Shape", axis, first.shape)
 	}
-	return false, nil
+	shape := copyInts(first.shape)
+	shape[axis] = 0
+	for _, t := range ts {
+		if len(t.shape) != len(shape) {
+			return nil, fmt.Errorf("Assertion Error: cannot concatenate shapes %v and %v", first.shape, t.shape)
+		}
+		for i := range shape {
+			if i != axis && t.shape[i] != shape[i] {
+				return nil, fmt.Errorf("Assertion Error: cannot concatenate shapes %v and %v along axis %d: axis %d differs", first.shape, t.shape, axis, i)
+			}
+		}
+		shape[axis] += t.shape[axis]
+	}
+	out := Zeros(shape)
+	offsets := make([]int, len(ts))
+	start := 0
+	for i, t := range ts {
+		offsets[i] = start
+		view, _ := out.gather(start, start+t.shape[axis], axis)
+		view.assign(t)
+		start += t.shape[axis]
+	}
+	return record(out, func(g *Tensor) []*Tensor {
+		grads := make([]*Tensor, len(ts))
+		for i, t := range ts {
+			part, _ := g.gather(offsets[i], offsets[i]+t.shape[axis], axis)
+			grads[i] = part.Clone()
+		}
+		return grads
+	}, ts...), nil
 }
 
-func (ue *UnknownError) Raise() error{
-	raised, err := ue.Check(ue.tensor)
-	ue.raised = raised
-	if err!=nil{
-		return fmt.Errorf("Unknown error raised.")			
+//Squeeze returns a view with every axis of size 1 removed.
+func (t *Tensor) Squeeze() *Tensor {
+	out := &Tensor{data: t.data, offset: t.offset, name: t.name}
+	for i, s := range t.shape {
+		if s != 1 {
+			out.shape = append(out.shape, s)
+			out.strides = append(out.strides, t.strides[i])
+		}
 	}
-	return nil
+	return record(out, func(g *Tensor) []*Tensor {
+		r, _ := g.Reshape(t.shape...)
+		return []*Tensor{r}
+	}, t)
 }
 
-// func main() {
-// 	// f := [][]int64{
-// 	// 	{4, 4},
-// 	// 	{1, 1},
-// 	// }
-// 	l := []int64{1, 3, 4, 5, 4, 3}
-// 	t := NewTensor(l)
-// 	// k := NewTensor(f)
-
-// 	// fmt.Println(reflect.ValueOf(k.value))
-// 	// fmt.Println(AssertionError(t, k))
-// 	//var x float64 = 3.4
-// 	v := reflect.ValueOf(t.number)
-// 	k := v.Type()
-
-// 	fmt.Println(k)
-// 	// fmt.Println("kind is float64:", v.Kind() == reflect.Float64)
-// 	// fmt.Println("value:", v.Float64())
-// 	//n := v.Interface().(v.Type)
-// 	value := reflect.New(t.number[0].(reflect.Type)).Interface()
-// 	fmt.Println(value)
-// 	//fmt.Println(n)
-// }
-
-
-
-// TODO: gradient tape, poveži z optimizerjem
-// uvozi data loaderje pa dodaj malo funkcionalnosti
-// tam pri automl
\ No newline at end of file
+//assign copies the elements of src, which must have the same shape, into t.
+func (t *Tensor) assign(src *Tensor) {
+	data, i := src.Data(), 0
+	t.forEach(func(pos int) {
+		t.data[pos] = data[i]
+		i++
+	})
+}
@@ -0,0 +1,230 @@
+package tensor
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlaceholder(t *testing.T) {
+	shape := []int{3, 3, 2}
+	p := Placeholder(shape)
+	if !reflect.DeepEqual(p.Shape(), shape) || p.Size() != 18 || p.ZeroCounts() != 18 {
+		t.Errorf("Something went wrong with the tensor: shape %v, size %d", p.Shape(), p.Size())
+	}
+}
+
+func TestNewTensorRoundTrip(t *testing.T) {
+	nested := [][]int{{1, 2, 3}, {4, 5, 6}}
+	x, err := NewTensor(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x.Shape(), []int{2, 3}) {
+		t.Fatalf("shape = %v, want [2 3]", x.Shape())
+	}
+	if x.At(1, 2) != 6 {
+		t.Errorf("At(1, 2) = %v, want 6", x.At(1, 2))
+	}
+	want := [][]float64{{1, 2, 3}, {4, 5, 6}}
+	if got := x.ToNested(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToNested() = %v, want %v", got, want)
+	}
+	if _, err := NewTensor([][]float64{{1, 2}, {3}}); err == nil {
+		t.Error("expected an error for a ragged slice")
+	}
+	if _, err := NewTensor([]string{"a"}); err == nil {
+		t.Error("expected an error for non numeric values")
+	}
+}
+
+func TestViews(t *testing.T) {
+	x, _ := FromSlice([]float64{1, 2, 3, 4, 5, 6}, []int{2, 3})
+	tr, err := x.Transpose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.IsContiguous() || tr.At(2, 1) != 6 {
+		t.Errorf("transpose view is wrong: %v", tr)
+	}
+	if got := tr.Data(); !reflect.DeepEqual(got, []float64{1, 4, 2, 5, 3, 6}) {
+		t.Errorf("transposed data = %v", got)
+	}
+	r, err := tr.Reshape(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Shape(), []int{6}) {
+		t.Errorf("inferred shape = %v, want [6]", r.Shape())
+	}
+	col, err := x.Gather(1, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := col.ToNested(); !reflect.DeepEqual(got, [][]float64{{2, 3}, {5, 6}}) {
+		t.Errorf("Gather = %v", got)
+	}
+	col.Set(10, 0, 0)
+	if x.At(0, 1) != 10 {
+		t.Error("Gather should return a view sharing memory")
+	}
+}
+
+func TestElementwise(t *testing.T) {
+	x, _ := NewTensor([]float64{1, 2, 3})
+	y, _ := NewTensor([]float64{4, 5, 6})
+	sum, err := x.Add(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sum.Data(), []float64{5, 7, 9}) {
+		t.Errorf("Add = %v", sum.Data())
+	}
+	if _, err := x.Divide(Zeros([]int{3})); err == nil {
+		t.Error("expected division by zero error")
+	}
+	if _, err := x.Add(Zeros([]int{2})); err == nil {
+		t.Error("expected a shape error")
+	}
+}
+
+func TestMatMulPropagatesNaN(t *testing.T) {
+	//a zero input does not hide a weight that diverged
+	x, _ := NewTensor([][]float64{{0, 1}})
+	w, _ := NewTensor([][]float64{{math.NaN(), math.Inf(1)}, {1, 2}})
+	out, err := x.MatMul(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := out.Data(); !math.IsNaN(d[0]) || !math.IsNaN(d[1]) {
+		t.Errorf("0 x [NaN +Inf] + 1 x [1 2] = %v, want [NaN NaN]", d)
+	}
+}
+
+func TestBroadcasting(t *testing.T) {
+	batch, _ := NewTensor([][]float64{{1, 2, 3}, {4, 5, 6}})
+	bias, _ := NewTensor([]float64{10, 20, 30})
+	sum, err := batch.Add(bias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{11, 22, 33}, {14, 25, 36}}; !reflect.DeepEqual(sum.ToNested(), want) {
+		t.Errorf("Add with bias = %v, want %v", sum.ToNested(), want)
+	}
+	col, _ := NewTensor([][]float64{{2}, {3}})
+	prod, err := col.Multiply(bias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{20, 40, 60}, {30, 60, 90}}; !reflect.DeepEqual(prod.ToNested(), want) {
+		t.Errorf("Multiply = %v, want %v", prod.ToNested(), want)
+	}
+	shape, err := BroadcastShapes([]int{8, 1, 6, 1}, []int{7, 1, 5})
+	if err != nil || !reflect.DeepEqual(shape, []int{8, 7, 6, 5}) {
+		t.Errorf("BroadcastShapes = %v, %v", shape, err)
+	}
+	_, err = BroadcastShapes([]int{2, 3}, []int{4, 3})
+	if err == nil || !strings.Contains(err.Error(), "axis 0") || !strings.Contains(err.Error(), "[2 3]") {
+		t.Errorf("expected an error naming both shapes and the axis, got %v", err)
+	}
+}
+
+func TestGradientTape(t *testing.T) {
+	x, _ := NewTensor([][]float64{{1, -2}, {0.5, 3}, {-1, 1}})
+	w, _ := NewTensor([][]float64{{0.2, -0.4, 0.1}, {0.3, 0.8, -0.5}})
+	b, _ := NewTensor([]float64{0.1, -0.2, 0.05})
+
+	loss := func(w, b *Tensor) *Tensor {
+		h, err := x.MatMul(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, _ = h.Add(b)
+		h = h.Tanh()
+		d, _ := h.Substract(Scalar(0.5))
+		return d.Square().ReduceMean()
+	}
+
+	tape := NewGradientTape()
+	tape.Watch(w, b)
+	grads, err := tape.Gradient(loss(w, b), w, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const eps = 1e-6
+	for i, p := range []*Tensor{w, b} {
+		for j := range p.data {
+			orig := p.data[j]
+			p.data[j] = orig + eps
+			up, _ := loss(w.Detach(), b.Detach()).Item()
+			p.data[j] = orig - eps
+			down, _ := loss(w.Detach(), b.Detach()).Item()
+			p.data[j] = orig
+			numeric := (up - down) / (2 * eps)
+			if got := grads[i].Data()[j]; math.Abs(got-numeric) > 1e-6 {
+				t.Errorf("gradient %d[%d] = %v, numeric %v", i, j, got, numeric)
+			}
+		}
+	}
+
+	unused := Ones([]int{2})
+	grads, _ = tape.Gradient(loss(w, b), unused)
+	if grads[0].Sum() != 0 {
+		t.Errorf("unrelated source should get a zero gradient, got %v", grads[0])
+	}
+}
+
+func TestTake(t *testing.T) {
+	x, _ := NewTensor([][]float64{{1, 2}, {3, 4}, {5, 6}})
+	rows, err := x.Take([]int{2, 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{5, 6}, {1, 2}, {5, 6}}; !reflect.DeepEqual(rows.ToNested(), want) {
+		t.Errorf("Take = %v, want %v", rows.ToNested(), want)
+	}
+	tape := NewGradientTape()
+	tape.Watch(x)
+	rows, _ = x.Take([]int{2, 0, 2})
+	grads, err := tape.Gradient(rows.ReduceSum(), x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{1, 1}, {0, 0}, {2, 2}}; !reflect.DeepEqual(grads[0].ToNested(), want) {
+		t.Errorf("gradient of Take = %v, want %v", grads[0].ToNested(), want)
+	}
+	if _, err := x.Take([]int{3}); err == nil {
+		t.Error("expected an out of range error")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	a, _ := NewTensor([][]float64{{1, 2}, {3, 4}})
+	b, _ := NewTensor([][]float64{{5}, {6}})
+	tape := NewGradientTape()
+	tape.Watch(a, b)
+	c, err := Concat([]*Tensor{a, b}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{1, 2, 5}, {3, 4, 6}}; !reflect.DeepEqual(c.ToNested(), want) {
+		t.Errorf("Concat = %v, want %v", c.ToNested(), want)
+	}
+	w, _ := NewTensor([]float64{1, 2, 3})
+	weighted, _ := c.Multiply(w)
+	grads, err := tape.Gradient(weighted.ReduceSum(), a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]float64{{1, 2}, {1, 2}}; !reflect.DeepEqual(grads[0].ToNested(), want) {
+		t.Errorf("gradient of a = %v, want %v", grads[0].ToNested(), want)
+	}
+	if want := [][]float64{{3}, {3}}; !reflect.DeepEqual(grads[1].ToNested(), want) {
+		t.Errorf("gradient of b = %v, want %v", grads[1].ToNested(), want)
+	}
+	if _, err := Concat([]*Tensor{a, b}, 0); err == nil {
+		t.Error("expected a shape error")
+	}
+}